@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/google/go-github/v37/github"
+)
+
+// The interfaces below cover the subset of each go-github service used by
+// the action. They are satisfied by the services of a *github.Client and
+// allow the api to be substituted in tests
+
+type checksService interface {
+	CreateCheckRun(ctx context.Context, owner, repo string, opts github.CreateCheckRunOptions) (*github.CheckRun, *github.Response, error)
+	GetCheckRun(ctx context.Context, owner, repo string, checkRunID int64) (*github.CheckRun, *github.Response, error)
+	UpdateCheckRun(ctx context.Context, owner, repo string, checkRunID int64, opts github.UpdateCheckRunOptions) (*github.CheckRun, *github.Response, error)
+	ListCheckRunsForRef(ctx context.Context, owner, repo, ref string, opts *github.ListCheckRunsOptions) (*github.ListCheckRunsResults, *github.Response, error)
+}
+
+type actionsService interface {
+	CreateWorkflowDispatchEventByID(ctx context.Context, owner, repo string, workflowID int64, event github.CreateWorkflowDispatchEventRequest) (*github.Response, error)
+	CreateWorkflowDispatchEventByFileName(ctx context.Context, owner, repo, workflowFileName string, event github.CreateWorkflowDispatchEventRequest) (*github.Response, error)
+	GetWorkflowByID(ctx context.Context, owner, repo string, workflowID int64) (*github.Workflow, *github.Response, error)
+	GetWorkflowRunByID(ctx context.Context, owner, repo string, runID int64) (*github.WorkflowRun, *github.Response, error)
+	CancelWorkflowRunByID(ctx context.Context, owner, repo string, runID int64) (*github.Response, error)
+	ListWorkflowJobs(ctx context.Context, owner, repo string, runID int64, opts *github.ListWorkflowJobsOptions) (*github.Jobs, *github.Response, error)
+	GetWorkflowJobLogs(ctx context.Context, owner, repo string, jobID int64, followRedirects bool) (*url.URL, *github.Response, error)
+	DownloadArtifact(ctx context.Context, owner, repo string, artifactID int64, followRedirects bool) (*url.URL, *github.Response, error)
+}
+
+type repositoriesService interface {
+	Get(ctx context.Context, owner, repo string) (*github.Repository, *github.Response, error)
+	GetContents(ctx context.Context, owner, repo, path string, opts *github.RepositoryContentGetOptions) (*github.RepositoryContent, []*github.RepositoryContent, *github.Response, error)
+	ListByOrg(ctx context.Context, org string, opts *github.RepositoryListByOrgOptions) ([]*github.Repository, *github.Response, error)
+	List(ctx context.Context, user string, opts *github.RepositoryListOptions) ([]*github.Repository, *github.Response, error)
+}
+
+type appsService interface {
+	GetInstallation(ctx context.Context, id int64) (*github.Installation, *github.Response, error)
+	FindOrganizationInstallation(ctx context.Context, org string) (*github.Installation, *github.Response, error)
+	FindUserInstallation(ctx context.Context, user string) (*github.Installation, *github.Response, error)
+	FindRepositoryInstallation(ctx context.Context, owner, repo string) (*github.Installation, *github.Response, error)
+}
+
+// requester performs requests for endpoints (or fields) go-github does not
+// yet support
+type requester interface {
+	NewRequest(method, urlStr string, body interface{}) (*http.Request, error)
+	Do(ctx context.Context, req *http.Request, v interface{}) (*github.Response, error)
+}
+
+// githubApi groups the GitHub api services used by the action
+type githubApi struct {
+	requester
+	Checks       checksService
+	Actions      actionsService
+	Repositories repositoriesService
+	// budget tracks the rate limit of the installation (or token) the api
+	// is authorized as, if known
+	budget *rateBudget
+}
+
+// newGithubApi wraps the services of a go-github client
+func newGithubApi(client *github.Client) *githubApi {
+	return &githubApi{
+		requester:    client,
+		Checks:       client.Checks,
+		Actions:      client.Actions,
+		Repositories: client.Repositories,
+	}
+}
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v37/github"
+	"github.com/sethvargo/go-githubactions"
+)
+
+const (
+	// defaultArtifactsPath is the directory of the workspace artifacts are
+	// downloaded into
+	defaultArtifactsPath = "artifacts"
+	// defaultMaxArtifactsSizeMB bounds the size of the artifacts downloaded
+	// by a dispatch, see artifactDownloads
+	defaultMaxArtifactsSizeMB = 1024
+	// artifactDownloadTimeout bounds the download of a single artifact
+	artifactDownloadTimeout = time.Minute * 10
+)
+
+// artifactDownloads configures the artifacts downloaded from the workflow
+// run of a dispatch once it completes
+type artifactDownloads struct {
+	// patterns are the names (or glob patterns of the names) of the
+	// artifacts to download, each of which must match at least one artifact
+	patterns []string
+	// path is the directory of the workspace the artifacts are extracted
+	// into, each in a directory named after the artifact
+	path string
+	// maxSizeBytes bounds both the total size of the archives downloaded
+	// and the total size of the files extracted from them
+	maxSizeBytes int64
+	// perRun places the artifacts of each dispatch in a directory named
+	// after its workflow run, when more than one dispatch is performed
+	perRun bool
+}
+
+// artifact extends the go-github representation of an artifact with the
+// digest of its archive (e.g. "sha256:...") which GitHub records for
+// artifacts uploaded by recent versions of actions/upload-artifact, and the
+// library does not yet expose
+type artifact struct {
+	*github.Artifact
+	Digest *string `json:"digest,omitempty"`
+}
+
+// downloadedArtifact records an artifact downloaded from the workflow run
+// of a dispatch
+type downloadedArtifact struct {
+	Name string `json:"name"`
+	// Path is the directory the artifact was extracted into, relative to
+	// the workspace
+	Path string `json:"path"`
+	// SizeInBytes and Sha256 describe the archive downloaded
+	SizeInBytes int64  `json:"size_in_bytes"`
+	Sha256      string `json:"sha256"`
+}
+
+// parseArtifactDownloads parses the download_artifacts, artifacts_path and
+// max_artifacts_size_mb inputs. Nothing is downloaded if
+// download_artifacts is not set
+func parseArtifactDownloads() (*artifactDownloads, error) {
+	patterns := []string{}
+	for _, pattern := range strings.FieldsFunc(os.Getenv("INPUT_DOWNLOAD_ARTIFACTS"), isListSeparator) {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("input 'download_artifacts' contains an invalid pattern %q: %w", pattern, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+
+	artifactsPath := strings.TrimSpace(os.Getenv("INPUT_ARTIFACTS_PATH"))
+	if artifactsPath == "" {
+		artifactsPath = defaultArtifactsPath
+	}
+	artifactsPath = filepath.Clean(artifactsPath)
+	if filepath.IsAbs(artifactsPath) || artifactsPath == ".." || strings.HasPrefix(artifactsPath, ".."+string(filepath.Separator)) {
+		return nil, errors.New("input 'artifacts_path' must be a directory within the workspace")
+	}
+
+	maxSizeMB := int64(defaultMaxArtifactsSizeMB)
+	if maxSizeString := os.Getenv("INPUT_MAX_ARTIFACTS_SIZE_MB"); maxSizeString != "" {
+		var err error
+		maxSizeMB, err = strconv.ParseInt(maxSizeString, 10, 64)
+		if err != nil || maxSizeMB < 1 {
+			return nil, errors.New("input 'max_artifacts_size_mb' must be a positive integer")
+		}
+	}
+
+	return &artifactDownloads{
+		patterns:     patterns,
+		path:         artifactsPath,
+		maxSizeBytes: maxSizeMB * 1024 * 1024,
+	}, nil
+}
+
+// downloadRunArtifacts waits for the workflow run of a dispatch to complete
+// and downloads the artifacts matching the download_artifacts input into
+// the workspace. The archive of each artifact is verified against its
+// digest, where GitHub records one, and each file extracted against the
+// checksum recorded in the archive
+func downloadRunArtifacts(ctx context.Context, client *GitHubClient, run *github.WorkflowRun) ([]downloadedArtifact, error) {
+	downloads := client.inputs.artifactDownloads
+	if run == nil {
+		return nil, errors.New("the workflow run of the dispatch could not be identified to download its artifacts from")
+	}
+
+	err := waitForRunCompletion(ctx, client, run.GetID())
+	if err != nil {
+		return nil, err
+	}
+
+	artifacts, err := client.ListWorkflowRunArtifacts(ctx, run.GetID())
+	if err != nil {
+		return nil, fmt.Errorf("Error listing the artifacts of workflow run %v: %w", run.GetID(), err)
+	}
+	selected, err := selectArtifacts(artifacts, downloads.patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	totalSize := int64(0)
+	for _, artifact := range selected {
+		totalSize += artifact.GetSizeInBytes()
+	}
+	if totalSize > downloads.maxSizeBytes {
+		return nil, newError(errorClassFailure, "The artifacts to download total %d bytes, more than the %d bytes max_artifacts_size_mb allows", totalSize, downloads.maxSizeBytes)
+	}
+
+	directory := downloads.path
+	if downloads.perRun {
+		directory = filepath.Join(directory, fmt.Sprint(run.GetID()))
+	}
+
+	downloaded := []downloadedArtifact{}
+	downloadBudget, extractBudget := downloads.maxSizeBytes, downloads.maxSizeBytes
+	for _, artifact := range selected {
+		destination, err := artifactDestination(directory, artifact.GetName())
+		if err != nil {
+			return nil, err
+		}
+		githubactions.Infof("Downloading artifact %v (%d bytes) to %v\n", artifact.GetName(), artifact.GetSizeInBytes(), destination)
+
+		result, archiveSize, extractedSize, err := client.downloadArtifact(ctx, artifact, destination, downloadBudget, extractBudget)
+		if err != nil {
+			return nil, fmt.Errorf("Error downloading artifact %v: %w", artifact.GetName(), err)
+		}
+		downloadBudget -= archiveSize
+		extractBudget -= extractedSize
+		downloaded = append(downloaded, result)
+	}
+	return downloaded, nil
+}
+
+// waitForRunCompletion polls a workflow run until it completes, since the
+// check of a dispatch may be completed before the run finishes uploading
+// its artifacts. The wait is bounded by wait_timeout_seconds
+func waitForRunCompletion(ctx context.Context, client *GitHubClient, runId int64) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(client.inputs.waitTimeoutSeconds))
+	defer cancel()
+
+	pollBackoff := newBackoff(client.inputs.pollBackoff)
+	for {
+		run, err := client.FetchWorkflowRun(ctx, runId)
+		if err != nil {
+			githubactions.Warningf("Error fetching workflow run %v: %v", runId, err.Error())
+		} else if run.GetStatus() == "completed" {
+			return nil
+		} else {
+			githubactions.Infof("    Waiting for workflow run %v to complete to download its artifacts ... %v\n", runId, run.GetStatus())
+		}
+
+		if sleepContext(ctx, pollBackoff.next()) != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return newError(errorClassTimeout, "Workflow run %v did not complete within %vs to download its artifacts", runId, client.inputs.waitTimeoutSeconds)
+			}
+			return fmt.Errorf("Abandoning waiting for workflow run %v: %w", runId, ctx.Err())
+		}
+	}
+}
+
+// selectArtifacts picks the artifacts matching any of the patterns, in the
+// order of the patterns. Every pattern must match an artifact, and none of
+// the artifacts picked may have expired or be named so that it cannot be
+// downloaded into a directory of its own, see isValidArtifactName
+func selectArtifacts(artifacts []*artifact, patterns []string) ([]*artifact, error) {
+	selected := []*artifact{}
+	for _, pattern := range patterns {
+		matched := false
+		for _, artifact := range artifacts {
+			if ok, _ := path.Match(pattern, artifact.GetName()); !ok {
+				continue
+			}
+			matched = true
+			if artifact.GetExpired() {
+				return nil, newError(errorClassNotFound, "Artifact %v has expired", artifact.GetName())
+			}
+			if !isValidArtifactName(artifact.GetName()) {
+				return nil, newError(errorClassFailure, "Artifact %q cannot be downloaded, as its name is not a valid directory name", artifact.GetName())
+			}
+			if !containsArtifact(selected, artifact) {
+				selected = append(selected, artifact)
+			}
+		}
+		if !matched {
+			return nil, newError(errorClassNotFound, "No artifact of the workflow run matches %q", pattern)
+		}
+	}
+	return selected, nil
+}
+
+// isValidArtifactName reports whether an artifact name can be used as the
+// name of the directory the artifact is extracted into, without escaping
+// the artifacts path
+func isValidArtifactName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// artifactDestination returns the directory an artifact is extracted into,
+// which must be within the directory of the downloads
+func artifactDestination(directory, name string) (string, error) {
+	destination := filepath.Join(directory, name)
+	relative, err := filepath.Rel(directory, destination)
+	if err != nil || relative == "." || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return "", newError(errorClassFailure, "Artifact %q would be extracted outside of %v", name, directory)
+	}
+	return destination, nil
+}
+
+func containsArtifact(artifacts []*artifact, artifact *artifact) bool {
+	for _, candidate := range artifacts {
+		if candidate.GetID() == artifact.GetID() {
+			return true
+		}
+	}
+	return false
+}
+
+// ListWorkflowRunArtifacts lists the artifacts of a workflow run in the
+// target repository. The request is built by hand so the digest of each
+// artifact is decoded
+func (client *GitHubClient) ListWorkflowRunArtifacts(ctx context.Context, runId int64) ([]*artifact, error) {
+	artifacts := []*artifact{}
+	for page := 1; ; page++ {
+		query := url.Values{}
+		query.Set("per_page", "100")
+		query.Set("page", fmt.Sprint(page))
+
+		u := fmt.Sprintf("repos/%v/%v/actions/runs/%v/artifacts?%s", client.inputs.targetOwner, client.inputs.targetRepository, runId, query.Encode())
+		req, err := client.api.NewRequest("GET", u, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var list struct {
+			Artifacts []*artifact `json:"artifacts"`
+		}
+		var response *github.Response
+		err = client.retry(ctx, fmt.Sprintf("listing the artifacts of workflow run %v", runId), retryOptions{}, func(ctx context.Context) error {
+			var err error
+			response, err = client.api.Do(ctx, req, &list)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		artifacts = append(artifacts, list.Artifacts...)
+		if response.NextPage == 0 {
+			return artifacts, nil
+		}
+	}
+}
+
+// downloadArtifact downloads the archive of an artifact in the target
+// repository and extracts it into the destination, a directory of the
+// workspace. The archive may be at most downloadLimit bytes, and the files
+// extracted from it extractLimit bytes in total. The sizes of both are
+// returned along with the artifact as downloaded
+func (client *GitHubClient) downloadArtifact(ctx context.Context, artifact *artifact, destination string, downloadLimit, extractLimit int64) (downloadedArtifact, int64, int64, error) {
+	var archiveUrl string
+	err := client.retry(ctx, fmt.Sprintf("downloading artifact %v", artifact.GetName()), retryOptions{}, func(ctx context.Context) error {
+		location, _, err := client.api.Actions.DownloadArtifact(ctx, client.inputs.targetOwner, client.inputs.targetRepository, artifact.GetID(), false)
+		if err == nil {
+			archiveUrl = location.String()
+		}
+		return err
+	})
+	if err != nil {
+		return downloadedArtifact{}, 0, 0, err
+	}
+
+	archive, err := os.CreateTemp("", "artifact-*.zip")
+	if err != nil {
+		return downloadedArtifact{}, 0, 0, err
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	downloadCtx, cancel := context.WithTimeout(ctx, artifactDownloadTimeout)
+	defer cancel()
+	body, err := client.openDownload(downloadCtx, archiveUrl)
+	if err != nil {
+		return downloadedArtifact{}, 0, 0, err
+	}
+	defer body.Close()
+
+	hash := sha256.New()
+	archiveSize, err := io.Copy(io.MultiWriter(archive, hash), io.LimitReader(body, downloadLimit+1))
+	if err != nil {
+		return downloadedArtifact{}, 0, 0, err
+	}
+	if archiveSize > downloadLimit {
+		return downloadedArtifact{}, 0, 0, newError(errorClassFailure, "the archive is larger than max_artifacts_size_mb allows")
+	}
+
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if err := verifyArtifactDigest(artifact, checksum); err != nil {
+		return downloadedArtifact{}, 0, 0, err
+	}
+
+	workspacePath := filepath.Join(os.Getenv("GITHUB_WORKSPACE"), destination)
+	extractedSize, err := extractArchive(archive.Name(), workspacePath, extractLimit)
+	if err != nil {
+		return downloadedArtifact{}, 0, 0, err
+	}
+
+	return downloadedArtifact{
+		Name:        artifact.GetName(),
+		Path:        filepath.ToSlash(destination),
+		SizeInBytes: archiveSize,
+		Sha256:      checksum,
+	}, archiveSize, extractedSize, nil
+}
+
+// verifyArtifactDigest checks the sha256 checksum of a downloaded archive
+// against the digest GitHub recorded for the artifact, if any
+func verifyArtifactDigest(artifact *artifact, checksum string) error {
+	digest := artifact.GetDigest()
+	if digest == "" {
+		githubactions.Debugf("Artifact %v has no digest to verify its archive against", artifact.GetName())
+		return nil
+	}
+
+	algorithm := strings.SplitN(digest, ":", 2)
+	if len(algorithm) != 2 || algorithm[0] != "sha256" {
+		githubactions.Warningf("Artifact %v has a digest of an unsupported algorithm, not verifying it: %v", artifact.GetName(), digest)
+		return nil
+	}
+	if !strings.EqualFold(algorithm[1], checksum) {
+		return newError(errorClassFailure, "the checksum of the archive (sha256:%v) does not match the digest of the artifact (%v)", checksum, digest)
+	}
+	return nil
+}
+
+// GetDigest returns the digest of the artifact, or "" if it has none
+func (artifact *artifact) GetDigest() string {
+	if artifact == nil || artifact.Digest == nil {
+		return ""
+	}
+	return *artifact.Digest
+}
+
+// extractArchive extracts the files of a zip archive into the destination,
+// returning their total size. Entries outside the destination (such as
+// "../file"), entries other than files and directories, and files which
+// would take the total past the limit are rejected. Each file is verified
+// against the checksum recorded in the archive as it is extracted
+func extractArchive(archivePath, destination string, limit int64) (int64, error) {
+	reader, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return 0, fmt.Errorf("the archive could not be read: %w", err)
+	}
+	defer reader.Close()
+
+	extracted := int64(0)
+	for _, file := range reader.File {
+		target, err := extractionPath(destination, file.Name)
+		if err != nil {
+			return extracted, err
+		}
+
+		mode := file.Mode()
+		if mode.IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return extracted, err
+			}
+			continue
+		}
+		if !mode.IsRegular() {
+			return extracted, fmt.Errorf("the archive contains %v, which is not a regular file", file.Name)
+		}
+		if file.UncompressedSize64 > uint64(limit-extracted) {
+			return extracted, newError(errorClassFailure, "the files of the archive are larger than max_artifacts_size_mb allows")
+		}
+
+		written, err := extractFile(file, target, limit-extracted)
+		extracted += written
+		if err != nil {
+			return extracted, fmt.Errorf("extracting %v failed: %w", file.Name, err)
+		}
+	}
+	return extracted, nil
+}
+
+// extractionPath returns the path a file of an archive is extracted to,
+// rejecting names which would place it outside of the destination
+func extractionPath(destination, name string) (string, error) {
+	target := filepath.Join(destination, filepath.FromSlash(name))
+	relative, err := filepath.Rel(destination, target)
+	if err != nil || filepath.IsAbs(filepath.FromSlash(name)) || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("the archive contains %v, which is outside of the directory it is extracted into", name)
+	}
+	return target, nil
+}
+
+// extractFile extracts a single file of an archive, at most limit bytes of
+// it. Reading the file to its end verifies its checksum
+func extractFile(file *zip.File, target string, limit int64) (int64, error) {
+	contents, err := file.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer contents.Close()
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return 0, err
+	}
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+
+	written, err := io.Copy(out, io.LimitReader(contents, limit+1))
+	if err != nil {
+		return written, err
+	}
+	if written > limit {
+		return written, newError(errorClassFailure, "the files of the archive are larger than max_artifacts_size_mb allows")
+	}
+	return written, out.Close()
+}
+
+// setArtifactOutputs sets the artifacts output, describing each artifact
+// downloaded as JSON, and the artifact_paths output, listing the directory
+// each was extracted into on a line of its own
+func setArtifactOutputs(artifacts []downloadedArtifact) {
+	rawArtifacts, err := json.Marshal(artifacts)
+	if err != nil {
+		exitWithError(fmt.Errorf("Error marshaling artifacts: %w", err))
+	}
+
+	paths := make([]string, 0, len(artifacts))
+	for _, artifact := range artifacts {
+		paths = append(paths, artifact.Path)
+	}
+	setOutputs(map[string]string{
+		"artifacts":      string(rawArtifacts),
+		"artifact_paths": strings.Join(paths, "\n"),
+	})
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/DrizlyInc/workflow-dispatch-action/action/m/v2/internal/fakegithub"
+)
+
+// zipArchive creates a zip archive of the given files, by name
+func zipArchive(t *testing.T, files map[string]string) []byte {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var archive bytes.Buffer
+	writer := zip.NewWriter(&archive)
+	for _, name := range names {
+		file, err := writer.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := file.Write([]byte(files[name])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return archive.Bytes()
+}
+
+func sha256Hex(content []byte) string {
+	checksum := sha256.Sum256(content)
+	return hex.EncodeToString(checksum[:])
+}
+
+// newArtifactsTestClient returns a test client downloading artifacts into a
+// temporary workspace, whose dispatched run completes with the given
+// artifacts
+func newArtifactsTestClient(t *testing.T, patterns []string, artifacts ...fakegithub.Artifact) (*fakegithub.Server, *GitHubClient, string) {
+	server, client := newTestClient(t)
+	client.inputs.artifactDownloads = &artifactDownloads{patterns: patterns, path: "artifacts", maxSizeBytes: 1024 * 1024}
+
+	workspace := t.TempDir()
+	os.Setenv("GITHUB_WORKSPACE", workspace)
+	t.Cleanup(func() { os.Unsetenv("GITHUB_WORKSPACE") })
+
+	server.OnDispatch = func(dispatch fakegithub.Dispatch) {
+		server.ScriptCheckRun(dispatchedCheckId(t, dispatch), fakegithub.CheckRunUpdate{Status: "completed", Conclusion: "success"})
+		server.ScriptWorkflowRun(dispatch.RunID,
+			fakegithub.WorkflowRunUpdate{Status: "in_progress"},
+			fakegithub.WorkflowRunUpdate{Status: "completed", Conclusion: "success"},
+		)
+		for _, artifact := range artifacts {
+			artifact.RunID = dispatch.RunID
+			server.AddArtifact(artifact)
+		}
+	}
+	return server, client, workspace
+}
+
+func TestDispatchDownloadArtifacts(t *testing.T) {
+	linux := zipArchive(t, map[string]string{"bin/app": "linux binary", "README.md": "readme"})
+	darwin := zipArchive(t, map[string]string{"bin/app": "darwin binary"})
+	coverage := zipArchive(t, map[string]string{"coverage.out": "mode: set"})
+
+	server, client, workspace := newArtifactsTestClient(t, []string{"build-*"},
+		fakegithub.Artifact{ID: 1, Name: "build-linux", Archive: linux, Digest: "sha256:" + sha256Hex(linux)},
+		fakegithub.Artifact{ID: 2, Name: "build-darwin", Archive: darwin},
+		fakegithub.Artifact{ID: 3, Name: "coverage", Archive: coverage},
+	)
+
+	result := dispatchWithClient(context.Background(), client)
+	if !result.Succeeded {
+		t.Fatalf("expected the dispatch to succeed, got error: %v", result.err)
+	}
+
+	if len(result.Artifacts) != 2 {
+		t.Fatalf("expected 2 artifacts to be downloaded, got %+v", result.Artifacts)
+	}
+	expected := downloadedArtifact{Name: "build-linux", Path: "artifacts/build-linux", SizeInBytes: int64(len(linux)), Sha256: sha256Hex(linux)}
+	if result.Artifacts[0] != expected {
+		t.Errorf("expected %+v, got %+v", expected, result.Artifacts[0])
+	}
+
+	for path, content := range map[string]string{
+		"artifacts/build-linux/bin/app":   "linux binary",
+		"artifacts/build-linux/README.md": "readme",
+		"artifacts/build-darwin/bin/app":  "darwin binary",
+	} {
+		extracted, err := os.ReadFile(filepath.Join(workspace, path))
+		if err != nil || string(extracted) != content {
+			t.Errorf("expected %v to be extracted with %q, got %q: %v", path, content, extracted, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(workspace, "artifacts", "coverage")); !os.IsNotExist(err) {
+		t.Errorf("expected the coverage artifact not to be downloaded, got %v", err)
+	}
+	for _, request := range server.Requests() {
+		if request == "GET /_artifacts/3" {
+			t.Error("expected the coverage artifact not to be downloaded")
+		}
+	}
+}
+
+func TestDispatchDownloadArtifactsRejected(t *testing.T) {
+	archive := zipArchive(t, map[string]string{"report.txt": "ok"})
+	bomb := zipArchive(t, map[string]string{"report.txt": strings.Repeat("0", 64*1024)})
+	escaping := zipArchive(t, map[string]string{"../../../outside.txt": "escaped"})
+
+	cases := []struct {
+		name          string
+		artifact      fakegithub.Artifact
+		maxSizeBytes  int64
+		expectedError string
+		// pattern selects the artifacts to download, "report" if empty
+		pattern string
+	}{
+		{"digest mismatch", fakegithub.Artifact{Name: "report", Archive: archive, Digest: "sha256:" + sha256Hex([]byte("tampered"))}, 1024, "does not match the digest", ""},
+		{"archive too large", fakegithub.Artifact{Name: "report", Archive: archive}, 16, "more than the 16 bytes", ""},
+		{"extracted too large", fakegithub.Artifact{Name: "report", Archive: bomb}, 4096, "files of the archive are larger", ""},
+		{"outside of the destination", fakegithub.Artifact{Name: "report", Archive: escaping}, 1024, "outside of the directory", ""},
+		{"expired", fakegithub.Artifact{Name: "report", Archive: archive, Expired: true}, 1024, "has expired", ""},
+		{"no match", fakegithub.Artifact{Name: "coverage", Archive: archive}, 1024, `No artifact of the workflow run matches "report"`, ""},
+		{"name outside of the path", fakegithub.Artifact{Name: "..", Archive: archive}, 1024, "not a valid directory name", "*"},
+		{"name with a separator", fakegithub.Artifact{Name: "../report", Archive: archive}, 1024, "not a valid directory name", "../report"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pattern := c.pattern
+			if pattern == "" {
+				pattern = "report"
+			}
+			_, client, workspace := newArtifactsTestClient(t, []string{pattern}, c.artifact)
+			client.inputs.artifactDownloads.maxSizeBytes = c.maxSizeBytes
+
+			result := dispatchWithClient(context.Background(), client)
+			if result.Succeeded {
+				t.Fatal("expected the dispatch to fail")
+			}
+			if !strings.Contains(result.Error, c.expectedError) {
+				t.Errorf("expected an error containing %q, got %v", c.expectedError, result.Error)
+			}
+			if _, err := os.Stat(filepath.Join(filepath.Dir(workspace), "outside.txt")); !os.IsNotExist(err) {
+				t.Errorf("expected no file to be extracted outside of the workspace, got %v", err)
+			}
+		})
+	}
+}
+
+func TestParseArtifactDownloads(t *testing.T) {
+	cases := []struct {
+		patterns string
+		path     string
+		maxSize  string
+		valid    bool
+	}{
+		{"build-*\ncoverage", "", "", true},
+		{"", "", "", true},
+		{"build-[", "", "", false},
+		{"build", "/tmp/artifacts", "", false},
+		{"build", "../artifacts", "", false},
+		{"build", "out/../artifacts", "10", true},
+		{"build", "", "0", false},
+	}
+
+	defer os.Unsetenv("INPUT_DOWNLOAD_ARTIFACTS")
+	defer os.Unsetenv("INPUT_ARTIFACTS_PATH")
+	defer os.Unsetenv("INPUT_MAX_ARTIFACTS_SIZE_MB")
+	for _, c := range cases {
+		os.Setenv("INPUT_DOWNLOAD_ARTIFACTS", c.patterns)
+		os.Setenv("INPUT_ARTIFACTS_PATH", c.path)
+		os.Setenv("INPUT_MAX_ARTIFACTS_SIZE_MB", c.maxSize)
+
+		downloads, err := parseArtifactDownloads()
+		if c.valid != (err == nil) {
+			t.Errorf("expected %+v to be valid=%v, got error: %v", c, c.valid, err)
+		}
+		if err == nil && (downloads == nil) != (c.patterns == "") {
+			t.Errorf("expected artifacts to be downloaded only when patterns are given, got %+v for %q", downloads, c.patterns)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/v37/github"
+)
+
+const (
+	defaultPollIntervalSeconds    = secondsBetweenChecks
+	defaultPollMaxIntervalSeconds = 60
+	defaultPollBackoffMultiplier  = 1.5
+	defaultPollJitter             = 0.1
+)
+
+// backoffPolicy configures how the interval between polls grows while a
+// check stays in the same state
+type backoffPolicy struct {
+	initial    time.Duration
+	max        time.Duration
+	multiplier float64
+	// jitter randomizes each interval by up to this fraction of it, so that
+	// concurrent waits do not poll in lockstep
+	jitter float64
+}
+
+// backoff tracks the interval until the next poll under a backoffPolicy
+type backoff struct {
+	policy  backoffPolicy
+	current time.Duration
+	random  *rand.Rand
+}
+
+func newBackoff(policy backoffPolicy) *backoff {
+	return &backoff{
+		policy:  policy,
+		current: policy.initial,
+		random:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// next returns the interval until the next poll and grows the interval for
+// the poll after it
+func (b *backoff) next() time.Duration {
+	interval := b.current
+
+	b.current = time.Duration(float64(b.current) * b.policy.multiplier)
+	if b.current > b.policy.max {
+		b.current = b.policy.max
+	}
+
+	if b.policy.jitter > 0 {
+		interval += time.Duration((b.random.Float64()*2 - 1) * b.policy.jitter * float64(interval))
+	}
+	return interval
+}
+
+// reset returns the interval to its initial value, for use when the state
+// being polled changes
+func (b *backoff) reset() {
+	b.current = b.policy.initial
+}
+
+// retryDelay returns how long the GitHub api asked to wait before retrying
+// a request which failed with the given error, from the Retry-After header,
+// the reset time of an exhausted rate limit or the pause of a rate budget
+func retryDelay(err error) (time.Duration, bool) {
+	var pausedErr *rateLimitPausedError
+	if errors.As(err, &pausedErr) {
+		return time.Until(pausedErr.until), true
+	}
+
+	var rateLimitErr *github.RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		return time.Until(rateLimitErr.Rate.Reset.Time) + time.Second, true
+	}
+
+	var abuseRateLimitErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseRateLimitErr) {
+		if abuseRateLimitErr.RetryAfter != nil {
+			return *abuseRateLimitErr.RetryAfter, true
+		}
+		// The transport pauses the budget for as long, see rateLimitedUntil
+		return secondaryRateLimitPause, true
+	}
+
+	var errorResponse *github.ErrorResponse
+	if errors.As(err, &errorResponse) && errorResponse.Response != nil {
+		return retryAfterHeader(errorResponse.Response.Header)
+	}
+
+	return 0, false
+}
+
+// retryAfterHeader parses the Retry-After header of a response, given in
+// seconds by the GitHub api
+func retryAfterHeader(header http.Header) (time.Duration, bool) {
+	seconds, err := strconv.ParseInt(header.Get("Retry-After"), 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	return time.Second * time.Duration(seconds), true
+}
+
+// sleepContext sleeps for the given duration, returning early with an
+// error if the context is done first
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
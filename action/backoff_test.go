@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DrizlyInc/workflow-dispatch-action/action/m/v2/internal/fakegithub"
+	"github.com/google/go-github/v37/github"
+)
+
+func TestBackoff(t *testing.T) {
+	b := newBackoff(backoffPolicy{initial: time.Second, max: time.Second * 4, multiplier: 2})
+
+	expected := []time.Duration{time.Second, time.Second * 2, time.Second * 4, time.Second * 4}
+	for i, interval := range expected {
+		if next := b.next(); next != interval {
+			t.Errorf("expected interval %d to be %v, got %v", i, interval, next)
+		}
+	}
+
+	b.reset()
+	if next := b.next(); next != time.Second {
+		t.Errorf("expected the interval to be reset to %v, got %v", time.Second, next)
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	b := newBackoff(backoffPolicy{initial: time.Second * 10, max: time.Second * 10, multiplier: 1, jitter: 0.2})
+
+	for i := 0; i < 100; i++ {
+		if next := b.next(); next < time.Second*8 || next > time.Second*12 {
+			t.Fatalf("expected the interval to be within 20%% of 10s, got %v", next)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	retryAfter := time.Second * 30
+	retryAfterResponse := &http.Response{StatusCode: http.StatusForbidden, Header: http.Header{"Retry-After": []string{"42"}}}
+
+	cases := []struct {
+		err      error
+		expected time.Duration
+		ok       bool
+	}{
+		{&github.RateLimitError{Rate: github.Rate{Reset: github.Timestamp{Time: time.Now().Add(time.Minute)}}}, time.Minute, true},
+		{fmt.Errorf("wrapped: %w", &github.AbuseRateLimitError{RetryAfter: &retryAfter}), retryAfter, true},
+		{&github.ErrorResponse{Response: retryAfterResponse}, time.Second * 42, true},
+		{&github.ErrorResponse{Response: &http.Response{StatusCode: http.StatusBadGateway}}, 0, false},
+		{errors.New("some error"), 0, false},
+	}
+
+	for _, c := range cases {
+		delay, ok := retryDelay(c.err)
+		if ok != c.ok || delay < c.expected || delay > c.expected+time.Second*2 {
+			t.Errorf("expected a delay of %v (%v) for %v, got %v (%v)", c.expected, c.ok, c.err, delay, ok)
+		}
+	}
+}
+
+func TestPollingUsesConditionalRequests(t *testing.T) {
+	server, client := newTestClient(t)
+	api := newGithubApi(server.ClientWithTransport(newEtagTransport(http.DefaultTransport)))
+	client.api = api
+	client.sourceApi = api
+
+	server.OnDispatch = func(dispatch fakegithub.Dispatch) {
+		server.ScriptCheckRun(dispatchedCheckId(t, dispatch),
+			fakegithub.CheckRunUpdate{Status: "queued"},
+			fakegithub.CheckRunUpdate{Status: "queued"},
+			fakegithub.CheckRunUpdate{Status: "queued"},
+			fakegithub.CheckRunUpdate{Status: "completed", Conclusion: "success"},
+		)
+	}
+
+	result := dispatchWithClient(context.Background(), client)
+	if !result.Succeeded {
+		t.Fatalf("expected the dispatch to succeed, got error: %v", result.err)
+	}
+	if server.NotModifiedCount() == 0 {
+		t.Error("expected polls of the unchanged check to be answered with 304 Not Modified")
+	}
+}
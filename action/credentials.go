@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/bradleyfalzon/ghinstallation"
+	"github.com/google/go-github/v37/github"
+	"github.com/sethvargo/go-githubactions"
+)
+
+// credentials are used to authenticate requests made to the GitHub api.
+// Each implementation wraps a base transport with whatever authentication
+// scheme it represents, scoped to the given owner/repository where the
+// scheme supports it. An empty repository scopes the transport to the owner
+type credentials interface {
+	transport(ctx context.Context, base http.RoundTripper, endpoints apiEndpoints, owner, repository string) (http.RoundTripper, error)
+}
+
+// tokenCredentials authenticate using a static token, such as a personal
+// access token or the GITHUB_TOKEN of the workflow invoking this action
+type tokenCredentials struct {
+	token string
+}
+
+func (creds tokenCredentials) transport(ctx context.Context, base http.RoundTripper, endpoints apiEndpoints, owner, repository string) (http.RoundTripper, error) {
+	if creds.token == "" {
+		return nil, errors.New("token is empty")
+	}
+
+	return newRateLimitTransport(budgetFor("token"), &tokenTransport{token: creds.token, base: base}), nil
+}
+
+// tokenFileCredentials authenticate using a static token read from a file
+// on disk, which allows the token to be provisioned by an earlier step
+// without passing through the action inputs
+type tokenFileCredentials struct {
+	path string
+}
+
+func (creds tokenFileCredentials) transport(ctx context.Context, base http.RoundTripper, endpoints apiEndpoints, owner, repository string) (http.RoundTripper, error) {
+	contents, err := os.ReadFile(creds.path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read token file %v: %w", creds.path, err)
+	}
+
+	return tokenCredentials{token: strings.TrimSpace(string(contents))}.transport(ctx, base, endpoints, owner, repository)
+}
+
+// appCredentials authenticate as an installation of a GitHub app
+type appCredentials struct {
+	appID          int64
+	privateKey     *rsa.PrivateKey
+	installationId int64
+}
+
+func (creds appCredentials) transport(ctx context.Context, base http.RoundTripper, endpoints apiEndpoints, owner, repository string) (http.RoundTripper, error) {
+	// https://github.com/google/go-github#authentication
+	// First, create an AppsTransport for initial auth
+	appTransport := ghinstallation.NewAppsTransportFromPrivateKey(base, creds.appID, creds.privateKey)
+	appTransport.BaseURL = endpoints.apiUrl
+
+	// use appTransport to generate a client
+	client, err := endpoints.newClient(appTransport)
+	if err != nil {
+		return nil, err
+	}
+
+	installationId, err := findInstallation(ctx, client.Apps, creds, owner, repository)
+	if err != nil {
+		return nil, err
+	}
+
+	installationTransport := ghinstallation.NewFromAppsTransport(appTransport, installationId)
+	return newRateLimitTransport(budgetFor(fmt.Sprintf("installation %d", installationId)), installationTransport), nil
+}
+
+// findInstallation returns the ID of the installation of the app to
+// authenticate as: the installation given as input if any, otherwise the
+// installation with access to the repository (or, when no repository is
+// given, the owner). This avoids assuming the app has a single
+// installation, since the app may be installed to multiple organizations
+func findInstallation(ctx context.Context, apps appsService, creds appCredentials, owner, repository string) (int64, error) {
+	if creds.installationId != -1 {
+		installation, _, err := apps.GetInstallation(ctx, creds.installationId)
+		if err != nil {
+			return 0, fmt.Errorf("No installation with ID %d found: %w", creds.installationId, err)
+		}
+		return installation.GetID(), nil
+	}
+
+	var installation *github.Installation
+	var err error
+	if repository == "" {
+		installation, _, err = apps.FindOrganizationInstallation(ctx, owner)
+		if err != nil {
+			installation, _, err = apps.FindUserInstallation(ctx, owner)
+		}
+	} else {
+		installation, _, err = apps.FindRepositoryInstallation(ctx, owner, repository)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("unable to find an installation of app %d with access to %v: %w", creds.appID, strings.TrimSuffix(owner+"/"+repository, "/"), err)
+	}
+	githubactions.Debugf("Using installation %d of app %d for %v", installation.GetID(), creds.appID, strings.TrimSuffix(owner+"/"+repository, "/"))
+
+	return installation.GetID(), nil
+}
+
+// tokenTransport adds a static token to the Authorization header of
+// every request before handing it to the base transport
+type tokenTransport struct {
+	token string
+	base  http.RoundTripper
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	authenticatedReq := req.Clone(req.Context())
+	authenticatedReq.Header.Set("Authorization", fmt.Sprintf("token %s", t.token))
+	return t.base.RoundTrip(authenticatedReq)
+}
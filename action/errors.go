@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/google/go-github/v37/github"
+)
+
+// errorClass categorizes an error by its cause, which determines how it is
+// handled and the exit code the action finishes with
+type errorClass int
+
+const (
+	// errorClassFailure covers errors with no more specific class, as well
+	// as checks which concluded unsuccessfully
+	errorClassFailure errorClass = iota
+	errorClassValidation
+	errorClassAuth
+	errorClassNotFound
+	errorClassRateLimit
+	errorClassTransient
+	errorClassTimeout
+)
+
+func (class errorClass) String() string {
+	switch class {
+	case errorClassValidation:
+		return "validation"
+	case errorClassAuth:
+		return "auth"
+	case errorClassNotFound:
+		return "not-found"
+	case errorClassRateLimit:
+		return "rate-limit"
+	case errorClassTransient:
+		return "transient"
+	case errorClassTimeout:
+		return "timeout"
+	default:
+		return "failure"
+	}
+}
+
+// exitCode returns the exit code the action finishes with for an error of
+// the class, so callers can distinguish the cause of a failure
+func (class errorClass) exitCode() int {
+	switch class {
+	case errorClassValidation:
+		return 2
+	case errorClassAuth:
+		return 3
+	case errorClassNotFound:
+		return 4
+	case errorClassRateLimit:
+		return 5
+	case errorClassTransient:
+		return 6
+	case errorClassTimeout:
+		return 7
+	default:
+		return 1
+	}
+}
+
+// checkConclusion returns the conclusion given to the check of a dispatch
+// which failed with an error of the class. Errors which need changes to the
+// inputs or credentials of the action to resolve require action
+func (class errorClass) checkConclusion() string {
+	switch class {
+	case errorClassValidation, errorClassAuth, errorClassNotFound:
+		return "action_required"
+	case errorClassTimeout:
+		return "timed_out"
+	default:
+		return "failure"
+	}
+}
+
+// remedy describes how an error of the class may be resolved, for the text
+// of the check of a dispatch which failed with it
+func (class errorClass) remedy() string {
+	switch class {
+	case errorClassValidation:
+		return "Check the inputs given to the action against the inputs declared by the target workflow, and that the target ref exists."
+	case errorClassAuth:
+		return "Check that the credentials given to the action have the permissions listed in the README for both repositories."
+	case errorClassNotFound:
+		return "Check that the target repository and workflow exist, and that the credentials given to the action have access to them."
+	case errorClassRateLimit:
+		return "The GitHub api rate limit was exhausted. Re-run the job once it resets."
+	case errorClassTransient:
+		return "The GitHub api failed to respond. Re-run the job to retry the dispatch."
+	default:
+		return ""
+	}
+}
+
+// classifiedError attaches an errorClass to an error
+type classifiedError struct {
+	class errorClass
+	err   error
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() error {
+	return e.err
+}
+
+// newError creates an error of the given class with a formatted message
+func newError(class errorClass, format string, args ...interface{}) error {
+	return &classifiedError{class: class, err: fmt.Errorf(format, args...)}
+}
+
+// errorClassOf returns the class of an error, as attached anywhere in its
+// chain of wrapped errors, or inferred from a GitHub api error if none was
+func errorClassOf(err error) errorClass {
+	var classified *classifiedError
+	if errors.As(err, &classified) {
+		return classified.class
+	}
+	return classifyGitHubError(err)
+}
+
+// classifyGitHubError infers the class of an error returned by the GitHub
+// api client from the type of error and status code of the response
+func classifyGitHubError(err error) errorClass {
+	var rateLimitErr *github.RateLimitError
+	var abuseRateLimitErr *github.AbuseRateLimitError
+	var pausedErr *rateLimitPausedError
+	var acceptedErr *github.AcceptedError
+	var errorResponse *github.ErrorResponse
+	var netErr net.Error
+
+	switch {
+	case errors.As(err, &rateLimitErr), errors.As(err, &abuseRateLimitErr), errors.As(err, &pausedErr):
+		return errorClassRateLimit
+	case errors.As(err, &acceptedErr):
+		return errorClassTransient
+	case errors.As(err, &errorResponse) && errorResponse.Response != nil:
+		return classifyStatusCode(errorResponse.Response.StatusCode)
+	case errors.Is(err, context.DeadlineExceeded):
+		return errorClassTimeout
+	case errors.As(err, &netErr):
+		return errorClassTransient
+	default:
+		return errorClassFailure
+	}
+}
+
+// classifyStatusCode infers the class of an error from the status code of
+// an unsuccessful api response
+func classifyStatusCode(statusCode int) errorClass {
+	switch {
+	case statusCode == http.StatusUnauthorized, statusCode == http.StatusForbidden:
+		return errorClassAuth
+	case statusCode == http.StatusNotFound:
+		return errorClassNotFound
+	case statusCode == http.StatusBadRequest, statusCode == http.StatusUnprocessableEntity:
+		return errorClassValidation
+	case statusCode == http.StatusTooManyRequests:
+		return errorClassRateLimit
+	case statusCode >= 500:
+		return errorClassTransient
+	default:
+		return errorClassFailure
+	}
+}
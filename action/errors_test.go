@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-github/v37/github"
+)
+
+func TestErrorClassOf(t *testing.T) {
+
+	errorResponse := func(statusCode int) error {
+		return &github.ErrorResponse{Response: &http.Response{StatusCode: statusCode}}
+	}
+
+	cases := []struct {
+		err   error
+		class errorClass
+	}{
+		{newError(errorClassValidation, "bad input"), errorClassValidation},
+		{fmt.Errorf("wrapped: %w", newError(errorClassAuth, "bad credentials")), errorClassAuth},
+		{fmt.Errorf("wrapped: %w", errorResponse(http.StatusNotFound)), errorClassNotFound},
+		{errorResponse(http.StatusUnauthorized), errorClassAuth},
+		{errorResponse(http.StatusUnprocessableEntity), errorClassValidation},
+		{errorResponse(http.StatusBadGateway), errorClassTransient},
+		{&github.RateLimitError{}, errorClassRateLimit},
+		{&github.AbuseRateLimitError{}, errorClassRateLimit},
+		{fmt.Errorf("waiting: %w", context.DeadlineExceeded), errorClassTimeout},
+		{errors.New("something else"), errorClassFailure},
+	}
+
+	for _, c := range cases {
+		if class := errorClassOf(c.err); class != c.class {
+			t.Errorf("expected %v for '%v', got %v", c.class, c.err, class)
+		}
+	}
+}
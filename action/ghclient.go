@@ -2,248 +2,421 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"net/http"
+	"strings"
 	"time"
 
-	"github.com/bradleyfalzon/ghinstallation"
 	"github.com/google/go-github/v37/github"
 	"github.com/sethvargo/go-githubactions"
 )
 
 type GitHubClient struct {
-	api                *github.Client
+	// api is authorized against the target repository
+	api *githubApi
+	// sourceApi is authorized against the repository dispatching the
+	// workflow, where checks are created
+	sourceApi          *githubApi
 	apiTimeoutDuration time.Duration
 	githubVars         githubVars
 	inputs             inputs
+	// workflow is the target workflow, see ResolveTargetWorkflow
+	workflow workflowReference
+	// validated is set once the target workflow and inputs have been
+	// validated, see validateDispatch
+	validated bool
+	// runNameCorrelates is set when the runs of the target workflow are
+	// named with the correlation token of their check, see
+	// ValidateTargetWorkflowInputs
+	runNameCorrelates bool
 }
 
 // NewGitHubClient creates an api client for interaction with GitHub
-// using an app installation transport
-func NewGitHubClient(githubVars githubVars, inputs inputs) *GitHubClient {
-	// https://github.com/google/go-github#authentication
-	// First, create an AppsTransport for initial auth
-	appTransport := ghinstallation.NewAppsTransportFromPrivateKey(http.DefaultTransport, inputs.appID, inputs.privateKey)
-	appTransport.BaseURL = githubVars.apiUrl
-
-	// use appTransport to generate a client
-	client := github.NewClient(&http.Client{Transport: appTransport})
-
-	// Get the list of installations
-	var allInstallations []*github.Installation
-	opt := &github.ListOptions{
-		PerPage: 100,
-	}
-	for {
-		installations, resp, err := client.Apps.ListInstallations(context.Background(), opt)
+// using the credentials provided as inputs. Separate transports are used
+// for the source and target repositories when they belong to different
+// owners, since each may be covered by a different app installation
+func NewGitHubClient(githubVars githubVars, inputs inputs) (*GitHubClient, error) {
+	api, err := newApiClient(context.Background(), githubVars, inputs, inputs.targetOwner, inputs.targetRepository)
+	if err != nil {
+		return nil, fmt.Errorf("Error constructing new Github Client for target repository: %w", err)
+	}
+
+	sourceApi := api
+	if !strings.EqualFold(githubVars.repositoryOwner, inputs.targetOwner) {
+		sourceApi, err = newApiClient(context.Background(), githubVars, inputs, githubVars.repositoryOwner, githubVars.repositoryName)
 		if err != nil {
-			githubactions.Fatalf("Error constructing new Github Client: %v", err.Error())
-		}
-		allInstallations = append(allInstallations, installations...)
-		if resp.NextPage == 0 {
-			break
-		}
-		opt.Page = resp.NextPage
-	}
-
-	var installationTransport *ghinstallation.Transport
-	if inputs.installationId == -1 {
-		// Take the first installation we fetched if none specified. This behavior
-		// is fine in circumstances where the GitHub app being used for authentication
-		// only has one installation. If the app is re-used (installed to multiple organizations or repos)
-		// then a specific installation ID must be given identifying which installation
-		// has the required permissions.
-		installationTransport = ghinstallation.NewFromAppsTransport(appTransport, *allInstallations[0].ID)
-	} else {
-		for _, installation := range allInstallations {
-			if *installation.ID == inputs.installationId {
-				installationTransport = ghinstallation.NewFromAppsTransport(appTransport, *installation.ID)
-				break
-			}
-		}
-		if installationTransport == nil {
-			githubactions.Fatalf("No installation with ID %d found", inputs.installationId)
+			return nil, fmt.Errorf("Error constructing new Github Client for source repository: %w", err)
 		}
 	}
 
 	return &GitHubClient{
-		api:                github.NewClient(&http.Client{Transport: installationTransport}),
+		api:                api,
+		sourceApi:          sourceApi,
 		apiTimeoutDuration: time.Second * 10,
 		githubVars:         githubVars,
 		inputs:             inputs,
+	}, nil
+}
+
+// withInputs returns a client for another dispatch to the same target
+// repository, sharing the api clients of this one
+func (client *GitHubClient) withInputs(inputs inputs) *GitHubClient {
+	return &GitHubClient{
+		api:                client.api,
+		sourceApi:          client.sourceApi,
+		apiTimeoutDuration: client.apiTimeoutDuration,
+		githubVars:         client.githubVars,
+		inputs:             inputs,
+	}
+}
+
+// newApiClient creates an api client for the GitHub api at GITHUB_API_URL
+// authorized by the credentials given as input for the given owner and
+// (optionally) repository
+func newApiClient(ctx context.Context, githubVars githubVars, inputs inputs, owner, repository string) (*githubApi, error) {
+	endpoints := newApiEndpoints(githubVars.apiUrl, inputs.uploadUrl)
+
+	transport, err := inputs.credentials.transport(ctx, inputs.baseTransport, endpoints, owner, repository)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := endpoints.newClient(newEtagTransport(transport))
+	if err != nil {
+		return nil, err
+	}
+
+	api := newGithubApi(client)
+	if rateLimited, ok := transport.(*rateLimitTransport); ok {
+		api.budget = rateLimited.budget
 	}
+	return api, nil
 }
 
 // ValidateTargetWorkflowExists checks that the workflow to be triggered,
 // as specified by the inputs, exists at the required refs on the target
-// repository and exits with an error message if not
-func (client *GitHubClient) ValidateTargetWorkflowExists(ctx context.Context) {
-	workflowFilepath := fmt.Sprintf(".github/workflows/%v.yml", client.inputs.workflowFilename)
-	defaultBranch := *client.GetTargetRepositoryDefaultBranch(ctx)
+// repository and returns a validation error if not
+func (client *GitHubClient) ValidateTargetWorkflowExists(ctx context.Context) error {
+	workflowFilepath := client.workflow.path
+	defaultBranch, err := client.GetTargetRepositoryDefaultBranch(ctx)
+	if err != nil {
+		return err
+	}
 
-	workflowExistsOnDefaultBranch := client.CheckIfFileExistsAtRef(ctx, client.inputs.targetOwner, client.inputs.targetRepository, workflowFilepath, defaultBranch)
-	workflowExistsOnTargetBranch := client.CheckIfFileExistsAtRef(ctx, client.inputs.targetOwner, client.inputs.targetRepository, workflowFilepath, client.inputs.targetRef)
+	workflowExistsOnDefaultBranch, err := client.CheckIfFileExistsAtRef(ctx, client.inputs.targetOwner, client.inputs.targetRepository, workflowFilepath, defaultBranch)
+	if err != nil {
+		return err
+	}
+	workflowExistsOnTargetBranch, err := client.CheckIfFileExistsAtRef(ctx, client.inputs.targetOwner, client.inputs.targetRepository, workflowFilepath, client.inputs.targetRef)
+	if err != nil {
+		return err
+	}
 
 	if !workflowExistsOnDefaultBranch || !workflowExistsOnTargetBranch {
 		githubactions.Errorf("The target workflow must exist on both the default branch (%v) and target ref (%v) of the target repository!", defaultBranch, client.inputs.targetRef)
 	}
 
 	if !workflowExistsOnDefaultBranch && !workflowExistsOnTargetBranch {
-		githubactions.Fatalf("No %v file was found at either ref. Perhaps you have a typo in the workflow filename?", workflowFilepath)
+		return newError(errorClassValidation, "No %v file was found at either ref. Perhaps you have a typo in the workflow filename?", workflowFilepath)
 	} else if !workflowExistsOnDefaultBranch && workflowExistsOnTargetBranch {
-		githubactions.Fatalf("Please add a dummy %v file to branch '%v' to 'register' the workflow with the GitHub API and try again!", workflowFilepath, defaultBranch)
+		return newError(errorClassValidation, "Please add a dummy %v file to branch '%v' to 'register' the workflow with the GitHub API and try again!", workflowFilepath, defaultBranch)
 	} else if workflowExistsOnDefaultBranch && !workflowExistsOnTargetBranch {
-		githubactions.Fatalf("The workflow was found on %v but not %v!", defaultBranch, client.inputs.targetRef)
+		return newError(errorClassValidation, "The workflow was found on %v but not %v!", defaultBranch, client.inputs.targetRef)
 	}
+
+	return nil
 }
 
 // GetTargetRepositoryDefaultBranch returns the name of the default branch
 // on the target repository specified by the inputs
-func (client *GitHubClient) GetTargetRepositoryDefaultBranch(ctx context.Context) *string {
-	apiTimeoutCtx, cancel := context.WithTimeout(ctx, client.apiTimeoutDuration)
-	defer cancel()
-
-	targetRepo, _, err := client.api.Repositories.Get(apiTimeoutCtx, client.inputs.targetOwner, client.inputs.targetRepository)
+func (client *GitHubClient) GetTargetRepositoryDefaultBranch(ctx context.Context) (string, error) {
+	var targetRepo *github.Repository
+	err := client.retry(ctx, "fetching the target repository", retryOptions{}, func(ctx context.Context) error {
+		var err error
+		targetRepo, _, err = client.api.Repositories.Get(ctx, client.inputs.targetOwner, client.inputs.targetRepository)
+		return err
+	})
 	if err != nil {
-		githubactions.Fatalf("Failed to fetch target repository information: %v", err.Error())
+		return "", fmt.Errorf("Failed to fetch target repository information: %w", err)
 	}
 
-	return targetRepo.DefaultBranch
+	return targetRepo.GetDefaultBranch(), nil
 }
 
 // CheckIfFileExistsAtRef returns a boolean indiciating whether or not a
-// repository contains a file at a specific ref
-func (client *GitHubClient) CheckIfFileExistsAtRef(ctx context.Context, owner, repository, filepath, ref string) bool {
-	apiTimeoutCtx, cancel := context.WithTimeout(ctx, client.apiTimeoutDuration)
-	defer cancel()
-
-	_, _, _, err := client.api.Repositories.GetContents(apiTimeoutCtx, owner, repository, filepath, &github.RepositoryContentGetOptions{
-		Ref: ref,
+// repository contains a file at a specific ref. Errors other than the file
+// not being found are returned
+func (client *GitHubClient) CheckIfFileExistsAtRef(ctx context.Context, owner, repository, filepath, ref string) (bool, error) {
+	err := client.retry(ctx, fmt.Sprintf("checking for %v at %v", filepath, ref), retryOptions{}, func(ctx context.Context) error {
+		_, _, _, err := client.api.Repositories.GetContents(ctx, owner, repository, filepath, &github.RepositoryContentGetOptions{
+			Ref: ref,
+		})
+		return err
 	})
+	if err != nil {
+		if errorClassOf(err) == errorClassNotFound {
+			return false, nil
+		}
+		return false, fmt.Errorf("Failed to check for %v at %v: %w", filepath, ref, err)
+	}
 
-	return err == nil
+	return true, nil
 }
 
 // CreateCheck creates a "queued" check on the repository using this action
 // to perform a workflow dispatch.
-func (client *GitHubClient) CreateCheck(ctx context.Context) *github.CheckRun {
+func (client *GitHubClient) CreateCheck(ctx context.Context) (*github.CheckRun, error) {
 	detailsUrl := fmt.Sprintf("%s/%s/%s/actions", client.githubVars.serverUrl, client.inputs.targetOwner, client.inputs.targetRepository)
 
-	apiTimeoutCtx, cancel := context.WithTimeout(ctx, client.apiTimeoutDuration)
-	defer cancel()
+	// The external ID identifies the check if an attempt to create it fails
+	// after it was created, so that a retry does not create another
+	externalId, err := newExternalId()
+	if err != nil {
+		return nil, err
+	}
 
-	checkRun, _, err := client.api.Checks.CreateCheckRun(apiTimeoutCtx, client.githubVars.repositoryOwner, client.githubVars.repositoryName, github.CreateCheckRunOptions{
-		Name:       client.inputs.workflowFilename,
-		HeadSHA:    client.githubVars.sha,
-		DetailsURL: &detailsUrl,
-		Status:     github.String("queued"),
-		StartedAt: &github.Timestamp{
-			Time: time.Now(),
-		},
-		Output: &github.CheckRunOutput{
-			Title:   github.String(client.inputs.workflowFilename),
-			Summary: github.String("This report will be populated by the triggered workflow"),
+	var checkRun *github.CheckRun
+	err = client.retry(ctx, "creating check", retryOptions{
+		applied: func(ctx context.Context) (bool, error) {
+			checkRun, err = client.findCheckByExternalId(ctx, externalId)
+			return checkRun != nil, err
 		},
+	}, func(ctx context.Context) error {
+		var err error
+		checkRun, _, err = client.sourceApi.Checks.CreateCheckRun(ctx, client.githubVars.repositoryOwner, client.githubVars.repositoryName, github.CreateCheckRunOptions{
+			Name:       client.inputs.checkName,
+			HeadSHA:    client.githubVars.sha,
+			DetailsURL: &detailsUrl,
+			ExternalID: github.String(externalId),
+			Status:     github.String("queued"),
+			StartedAt: &github.Timestamp{
+				Time: time.Now(),
+			},
+			Output: &github.CheckRunOutput{
+				Title:   github.String(client.inputs.checkName),
+				Summary: github.String("This report will be populated by the triggered workflow"),
+			},
+		})
+		return err
 	})
 
 	if err != nil {
-		githubactions.Fatalf("Error creating check: %v", err.Error())
+		return nil, fmt.Errorf("Error creating check: %w", err)
 	}
 
 	if checkRun.ID == nil {
-		githubactions.Fatalf("CreateCheckRun did not return a check ID. Exiting.")
+		return nil, newError(errorClassFailure, "CreateCheckRun did not return a check ID. Exiting.")
 	}
 
-	githubactions.Infof("Created new check here: %s\n", *checkRun.HTMLURL)
+	githubactions.Infof("Created new check here: %s\n", checkRun.GetHTMLURL())
 
-	return checkRun
+	return checkRun, nil
+}
+
+// findCheckByExternalId returns the check with the given external ID on the
+// commit of the dispatching workflow, or nil if there is none
+func (client *GitHubClient) findCheckByExternalId(ctx context.Context, externalId string) (*github.CheckRun, error) {
+	var checkRuns *github.ListCheckRunsResults
+	err := client.attempt(ctx, func(ctx context.Context) error {
+		var err error
+		checkRuns, _, err = client.sourceApi.Checks.ListCheckRunsForRef(ctx, client.githubVars.repositoryOwner, client.githubVars.repositoryName, client.githubVars.sha, &github.ListCheckRunsOptions{
+			CheckName:   github.String(client.inputs.checkName),
+			ListOptions: github.ListOptions{PerPage: 100},
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, checkRun := range checkRuns.CheckRuns {
+		if checkRun.GetExternalID() == externalId {
+			return checkRun, nil
+		}
+	}
+	return nil, nil
+}
+
+// newExternalId generates a random identifier for a check
+func newExternalId() (string, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", fmt.Errorf("Error generating check external ID: %w", err)
+	}
+	return hex.EncodeToString(id), nil
 }
 
 // DispatchWorkflow sends a workflow_dispatch event to the target repository
 // and ref using the GitHub api
-func (client *GitHubClient) DispatchWorkflow(ctx context.Context, checkRun *github.CheckRun) {
-	addDefaultWorkflowInputs(&client.inputs, client.githubVars, checkRun)
-
-	fullWorkflowFilename := fmt.Sprintf("%s.yml", client.inputs.workflowFilename)
-	githubactions.Infof("Dispatching to %v workflow in %v/%v@%v\n", fullWorkflowFilename, client.inputs.targetOwner, client.inputs.targetRepository, client.inputs.targetRef)
+func (client *GitHubClient) DispatchWorkflow(ctx context.Context, checkRun *github.CheckRun) error {
+	err := addDefaultWorkflowInputs(&client.inputs, client.githubVars, checkRun)
+	if err != nil {
+		return err
+	}
 
-	apiTimeoutCtx, cancel := context.WithTimeout(ctx, client.apiTimeoutDuration)
-	defer cancel()
+	githubactions.Infof("Dispatching to %v workflow in %v/%v@%v\n", client.workflow.path, client.inputs.targetOwner, client.inputs.targetRepository, client.inputs.targetRef)
 
-	_, err := client.api.Actions.CreateWorkflowDispatchEventByFileName(apiTimeoutCtx, client.inputs.targetOwner, client.inputs.targetRepository, fullWorkflowFilename, github.CreateWorkflowDispatchEventRequest{
+	event := github.CreateWorkflowDispatchEventRequest{
 		Ref:    client.inputs.targetRef,
 		Inputs: client.inputs.workflowInputs,
+	}
+
+	// A dispatch which fails after the workflow was triggered must not be
+	// repeated, so before retrying look for the run it would have created.
+	// The run takes a few seconds to be listed, and only a run matching the
+	// check exactly counts, see lookupWorkflowRun. Without the token in the
+	// run-name no run can match, so the dispatch is not retried at all
+	dispatchedAt := time.Now()
+	err = client.retry(ctx, "dispatching event", retryOptions{
+		applied: func(ctx context.Context) (bool, error) {
+			if !client.runNameCorrelates {
+				return false, errors.New("the run-name of the workflow does not include the check_id, so its runs cannot be identified")
+			}
+			_, _, err := client.lookupWorkflowRun(ctx, checkRun.GetID(), dispatchedAt, false)
+			if errors.Is(err, errWorkflowRunNotFound) {
+				return false, nil
+			}
+			return err == nil, err
+		},
+	}, func(ctx context.Context) error {
+		var err error
+		if client.workflow.id != 0 {
+			_, err = client.api.Actions.CreateWorkflowDispatchEventByID(ctx, client.inputs.targetOwner, client.inputs.targetRepository, client.workflow.id, event)
+		} else {
+			_, err = client.api.Actions.CreateWorkflowDispatchEventByFileName(ctx, client.inputs.targetOwner, client.inputs.targetRepository, client.workflow.filename(), event)
+		}
+		return err
 	})
 
 	if err != nil {
-		msg := fmt.Sprintf("Error disptaching event: %v", err.Error())
-		client.CompleteCheckAsFailure(context.Background(), checkRun, msg)
-		githubactions.Fatalf(msg)
+		return fmt.Errorf("Error dispatching event: %w", err)
 	}
+
+	return nil
 }
 
-// CompleteCheckAsFailure updates the status of a GitHub check to "failure",
-// providing the given "reason" as the summary of the check
-func (client *GitHubClient) CompleteCheckAsFailure(ctx context.Context, checkRun *github.CheckRun, reason string) {
-	apiTimeoutCtx, cancel := context.WithTimeout(ctx, client.apiTimeoutDuration)
-	defer cancel()
+// CompleteCheck updates the status of a GitHub check to "completed" with the
+// given conclusion, summary and (if not empty) text. The details url of the
+// check is set to that of the given checkRun, see withRunDetails
+func (client *GitHubClient) CompleteCheck(ctx context.Context, checkRun *github.CheckRun, conclusion, summary, text string) error {
+	output := &github.CheckRunOutput{
+		Title:   github.String(checkRun.GetOutput().GetTitle()),
+		Summary: github.String(summary),
+	}
+	if text != "" {
+		output.Text = github.String(text)
+	}
 
-	_, _, err := client.api.Checks.UpdateCheckRun(apiTimeoutCtx, client.githubVars.repositoryOwner, client.githubVars.repositoryName, *checkRun.ID, github.UpdateCheckRunOptions{
-		Name:       checkRun.GetName(),
-		Status:     github.String("completed"),
-		Conclusion: github.String("failure"),
-		CompletedAt: &github.Timestamp{
-			Time: time.Now(),
-		},
-		Output: &github.CheckRunOutput{
-			Title:   github.String(checkRun.Output.GetTitle()),
-			Summary: github.String(reason),
-		},
+	err := client.retry(ctx, fmt.Sprintf("marking check %v", conclusion), retryOptions{}, func(ctx context.Context) error {
+		_, _, err := client.sourceApi.Checks.UpdateCheckRun(ctx, client.githubVars.repositoryOwner, client.githubVars.repositoryName, checkRun.GetID(), github.UpdateCheckRunOptions{
+			Name:       checkRun.GetName(),
+			DetailsURL: checkRun.DetailsURL,
+			Status:     github.String("completed"),
+			Conclusion: github.String(conclusion),
+			CompletedAt: &github.Timestamp{
+				Time: time.Now(),
+			},
+			Output: output,
+		})
+		return err
 	})
 	if err != nil {
-		githubactions.Errorf(reason)
-		githubactions.Fatalf("Error marking check failed after dispatch error: %v", err.Error())
+		return fmt.Errorf("Error marking check %v %v: %w", checkRun.GetID(), conclusion, err)
 	}
+
+	return nil
 }
 
-// FetchCheckWithRetries retrieves an existing check from the repository
-// using this action to send a workflow dispatch. This method retries the api
-// call up to a maximum number of attempts with delays in-between in order
-// to overcome the eventual consistency delays with the GitHub api.
-func (client *GitHubClient) FetchCheckWithRetries(ctx context.Context, checkId int64) (*github.CheckRun, error) {
-	secondsBetweenAttempts := 1
-	maxAttempts := 4
-	attempts := 0
+// AnnotateCheck adds annotations to a check, (re)concluding it with the
+// given conclusion and summary. The title and text of the given checkRun
+// are kept, so it should be the check as last fetched
+func (client *GitHubClient) AnnotateCheck(ctx context.Context, checkRun *github.CheckRun, conclusion, summary string, annotations []*github.CheckRunAnnotation) error {
+	err := client.retry(ctx, fmt.Sprintf("annotating check %v", checkRun.GetID()), retryOptions{}, func(ctx context.Context) error {
+		_, _, err := client.sourceApi.Checks.UpdateCheckRun(ctx, client.githubVars.repositoryOwner, client.githubVars.repositoryName, checkRun.GetID(), github.UpdateCheckRunOptions{
+			Name:       checkRun.GetName(),
+			Conclusion: github.String(conclusion),
+			Output: &github.CheckRunOutput{
+				Title:       github.String(checkRun.GetOutput().GetTitle()),
+				Summary:     github.String(summary),
+				Text:        checkRun.GetOutput().Text,
+				Annotations: annotations,
+			},
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Error annotating check %v: %w", checkRun.GetID(), err)
+	}
 
-	for {
-		check, err := client.FetchCheck(ctx, client.githubVars, checkId)
-		attempts += 1
+	return nil
+}
 
-		if err != nil {
-			if ctx.Err() != nil {
-				return nil, ctx.Err()
-			}
+// UpdateCheck updates a check on the repository using this action to
+// perform a workflow dispatch, returning the updated check
+func (client *GitHubClient) UpdateCheck(ctx context.Context, checkId int64, opts github.UpdateCheckRunOptions) (*github.CheckRun, error) {
+	var checkRun *github.CheckRun
+	err := client.retry(ctx, fmt.Sprintf("updating check %v", checkId), retryOptions{}, func(ctx context.Context) error {
+		var err error
+		checkRun, _, err = client.sourceApi.Checks.UpdateCheckRun(ctx, client.githubVars.repositoryOwner, client.githubVars.repositoryName, checkId, opts)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error updating check %v: %w", checkId, err)
+	}
 
-			githubactions.Warningf("Error fetching check %v (attempt %v of %v): %v", checkId, attempts, maxAttempts, err.Error())
-			if attempts == maxAttempts {
-				return nil, fmt.Errorf("Exceeded max attempts fetching check %v!", checkId)
-			}
+	return checkRun, nil
+}
 
-			time.Sleep(time.Second * time.Duration(secondsBetweenAttempts))
-		} else {
-			return check, nil
-		}
+// CompleteCheckFromRun concludes a GitHub check with the conclusion of the
+// given workflow run, for use when the run finished without updating the check
+func (client *GitHubClient) CompleteCheckFromRun(ctx context.Context, checkRun *github.CheckRun, run *github.WorkflowRun) error {
+	summary := fmt.Sprintf("The [triggered workflow run](%v) completed without updating this check", run.GetHTMLURL())
+	return client.CompleteCheck(ctx, withRunDetails(checkRun, run.GetHTMLURL()), checkConclusionFromRun(run), summary, "")
+}
+
+// withRunDetails returns a copy of the check whose details url is that of
+// the given workflow run, if it is known
+func withRunDetails(checkRun *github.CheckRun, runUrl string) *github.CheckRun {
+	withDetails := *checkRun
+	if runUrl != "" {
+		withDetails.DetailsURL = github.String(runUrl)
 	}
+	return &withDetails
+}
 
+// FetchCheckWithRetries retrieves an existing check from the repository
+// using this action to send a workflow dispatch. In addition to the errors
+// retried for every api call, a check which is not found is retried in
+// order to overcome the eventual consistency delays with the GitHub api.
+func (client *GitHubClient) FetchCheckWithRetries(ctx context.Context, checkId int64) (*github.CheckRun, error) {
+	var check *github.CheckRun
+	err := client.retry(ctx, fmt.Sprintf("fetching check %v", checkId), retryOptions{
+		retryable: func(err error) bool {
+			return isRetryableError(err) || errorClassOf(err) == errorClassNotFound
+		},
+	}, func(ctx context.Context) error {
+		var err error
+		check, _, err = client.sourceApi.Checks.GetCheckRun(ctx, client.githubVars.repositoryOwner, client.githubVars.repositoryName, checkId)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error fetching check %v: %w", checkId, err)
+	}
+
+	return check, nil
 }
 
 // FetchCheck performs a single GetCheckRun call against the GitHub api
 // to get an existing check from the repository using this action.
 func (client *GitHubClient) FetchCheck(ctx context.Context, githubVars githubVars, checkId int64) (*github.CheckRun, error) {
-
-	apiTimeoutCtx, cancel := context.WithTimeout(ctx, client.apiTimeoutDuration)
-	defer cancel()
-
-	check, _, err := client.api.Checks.GetCheckRun(apiTimeoutCtx, githubVars.repositoryOwner, githubVars.repositoryName, checkId)
+	var check *github.CheckRun
+	err := client.attempt(ctx, func(ctx context.Context) error {
+		var err error
+		check, _, err = client.sourceApi.Checks.GetCheckRun(ctx, githubVars.repositoryOwner, githubVars.repositoryName, checkId)
+		return err
+	})
 	return check, err
 }
@@ -5,5 +5,7 @@ go 1.16
 require (
 	github.com/bradleyfalzon/ghinstallation v1.1.1
 	github.com/google/go-github/v37 v37.0.0
+	github.com/santhosh-tekuri/jsonschema/v5 v5.3.0
 	github.com/sethvargo/go-githubactions v0.4.0
+	gopkg.in/yaml.v3 v3.0.1
 )
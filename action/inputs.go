@@ -1,79 +1,125 @@
 package main
 
 import (
-	"crypto/rsa"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"path"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/go-github/v37/github"
 	"github.com/sethvargo/go-githubactions"
 )
 
 type inputs struct {
-	appID              int64
-	privateKey         *rsa.PrivateKey
+	credentials credentials
+	// baseTransport is the transport underlying every request to the
+	// GitHub api, see parseTransport
+	baseTransport http.RoundTripper
+	// uploadUrl overrides the upload url derived from GITHUB_API_URL
+	uploadUrl string
+	// targetRepository and targetOwner identify the single repository a
+	// client dispatches to, see targets for the repositories (or patterns)
+	// given as input
 	targetRepository   string
 	targetOwner        string
+	targets            []target
+	targetTopics       []string
+	maxParallel        int64
 	targetRef          string
 	workflowFilename   string
+	checkName          string
 	waitForCheck       bool
 	waitTimeoutSeconds int64
-	installationId     int64
-	workflowInputs     map[string]interface{}
+	// successConclusions are the conclusions of the check which count as
+	// success, see isSuccessConclusion
+	successConclusions []string
+	// outputPrefix is prepended to the keys of a JSON output to name the
+	// outputs they are promoted to, see promoteOutputs
+	outputPrefix string
+	// outputSchema validates the outputs of the check, if given
+	outputSchema *outputSchema
+	// artifactDownloads configures the artifacts downloaded from the
+	// workflow run once it completes, if any
+	artifactDownloads *artifactDownloads
+	// pollBackoff configures the interval between polls while waiting
+	pollBackoff backoffPolicy
+	// runLookupInterval and runLookupTimeout configure the search for the
+	// workflow run created by a dispatch, see FindDispatchedWorkflowRun
+	runLookupInterval time.Duration
+	runLookupTimeout  time.Duration
+	// retryPolicy configures how failed api calls are retried
+	retryPolicy retryPolicy
+	// onTimeout and onCancel are the interruptPolicy applied when waiting
+	// for the check times out or the job is cancelled, see interruptionOf
+	onTimeout string
+	onCancel  string
+	// streamProgress logs the jobs and steps of the dispatched workflow run
+	// while polling for the check, see runProgress
+	streamProgress bool
+	// stepSummary writes a report of the dispatches to the job summary,
+	// redacting the workflow inputs matching redactInputs or containing
+	// secretValues, see writeDispatchSummary
+	stepSummary  bool
+	redactInputs []string
+	secretValues []string
+	// waitStrategy selects how to wait for the check, see newWaiter
+	waitStrategy           string
+	webhookAddress         string
+	webhookSecret          string
+	webhookFallbackSeconds int64
+	// waiter waits for the check, see checkWaiter
+	waiter         Waiter
+	workflowInputs map[string]interface{}
+	// matrix holds the inputs specific to each dispatch when more than one
+	// dispatch per target was requested, see matrixEntry for the inputs of
+	// the dispatch a client performs
+	matrix      []map[string]interface{}
+	matrixEntry map[string]interface{}
+	// multipleDispatches is set when the dispatch is one of several
+	// performed concurrently, see expandDispatches
+	multipleDispatches bool
 }
 
 func parseInputs() (inputs, error) {
 
-	appIDString, ok := os.LookupEnv("INPUT_APP_ID")
-	if !ok {
-		return inputs{}, errors.New("input 'app_id' not set")
-	}
-	appID, err := strconv.ParseInt(appIDString, 10, 64)
+	credentials, err := parseCredentials()
 	if err != nil {
-		return inputs{}, errors.New("input 'app_id' must be an integer")
+		return inputs{}, err
 	}
 
-	installationId := int64(-1)
-	installationIdString, ok := os.LookupEnv("APP_INSTALLATION_ID")
-	if ok {
-		installationId, err = strconv.ParseInt(installationIdString, 10, 64)
-		if err != nil {
-			return inputs{}, errors.New("APP_INSTALLATION_ID must be an integer")
-		}
+	baseTransport, err := parseTransport()
+	if err != nil {
+		return inputs{}, err
 	}
 
-	privateKeyString, ok := os.LookupEnv("INPUT_PRIVATE_KEY")
+	targetRepositoryString, ok := os.LookupEnv("INPUT_TARGET_REPOSITORY")
 	if !ok {
-		return inputs{}, errors.New("input 'private_key' not set")
-	}
-	block, _ := pem.Decode([]byte(privateKeyString))
-	if block == nil {
-		return inputs{}, errors.New("input 'private_key' not a PEM block")
-	}
-	if block.Type != "RSA PRIVATE KEY" {
-		return inputs{}, fmt.Errorf("input 'private_key' PEM block not an RSA private key. It is a %v", block.Type)
+		return inputs{}, errors.New("input 'target_repository' not set")
 	}
-	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	targets, err := parseTargets(targetRepositoryString)
 	if err != nil {
-		return inputs{}, fmt.Errorf("input 'private_key' RSA Private Key not formatted properly: %w", err)
+		return inputs{}, err
 	}
 
-	targetRepository, ok := os.LookupEnv("INPUT_TARGET_REPOSITORY")
-	if !ok {
-		return inputs{}, errors.New("input 'target_repository' not set")
+	targetTopics := []string{}
+	for _, topic := range strings.FieldsFunc(os.Getenv("INPUT_TARGET_TOPICS"), isListSeparator) {
+		targetTopics = append(targetTopics, strings.TrimSpace(topic))
 	}
-	targetOwnerRepo := strings.Split(targetRepository, "/")
-	if len(targetOwnerRepo) != 2 {
-		return inputs{}, errors.New("input 'target_repository' not formatted as owner/repo-name")
+
+	maxParallel := int64(defaultMaxParallel)
+	if maxParallelString := os.Getenv("INPUT_MAX_PARALLEL"); maxParallelString != "" {
+		maxParallel, err = strconv.ParseInt(maxParallelString, 10, 64)
+		if err != nil || maxParallel < 1 {
+			return inputs{}, errors.New("input 'max_parallel' must be a positive integer")
+		}
 	}
-	targetOwner := targetOwnerRepo[0]
-	targetRepository = targetOwnerRepo[1]
 
 	targetRef, ok := os.LookupEnv("INPUT_TARGET_REF")
 	if !ok {
@@ -100,34 +146,360 @@ func parseInputs() (inputs, error) {
 		return inputs{}, errors.New("input 'wait_timeout_seconds' must be an integer")
 	}
 
-	workflowInputs := map[string]interface{}{}
+	successConclusions, err := parseSuccessConclusions(os.Getenv("INPUT_SUCCESS_CONCLUSIONS"))
+	if err != nil {
+		return inputs{}, err
+	}
+
+	outputPrefix, ok := os.LookupEnv("INPUT_OUTPUT_PREFIX")
+	if !ok {
+		outputPrefix = defaultOutputPrefix
+	}
+
+	outputSchema, err := parseOutputSchema()
+	if err != nil {
+		return inputs{}, err
+	}
+
+	artifactDownloads, err := parseArtifactDownloads()
+	if err != nil {
+		return inputs{}, err
+	}
+	if artifactDownloads != nil && !waitForCheck {
+		return inputs{}, errors.New("input 'download_artifacts' requires 'wait_for_check' to be true")
+	}
+
+	pollBackoff, err := parsePollBackoff()
+	if err != nil {
+		return inputs{}, err
+	}
+
+	retryPolicy, err := parseRetryPolicy()
+	if err != nil {
+		return inputs{}, err
+	}
+
+	onTimeout, err := parseInterruptPolicy("on_timeout")
+	if err != nil {
+		return inputs{}, err
+	}
+	onCancel, err := parseInterruptPolicy("on_cancel")
+	if err != nil {
+		return inputs{}, err
+	}
+
+	streamProgress := true
+	if streamProgressString := os.Getenv("INPUT_STREAM_PROGRESS"); streamProgressString != "" {
+		streamProgress, err = strconv.ParseBool(streamProgressString)
+		if err != nil {
+			return inputs{}, fmt.Errorf("input 'stream_progress' is not a boolean: %w", err)
+		}
+	}
+
+	stepSummary := true
+	if stepSummaryString := os.Getenv("INPUT_STEP_SUMMARY"); stepSummaryString != "" {
+		stepSummary, err = strconv.ParseBool(stepSummaryString)
+		if err != nil {
+			return inputs{}, fmt.Errorf("input 'step_summary' is not a boolean: %w", err)
+		}
+	}
+	redactInputs, err := parseRedactInputs()
+	if err != nil {
+		return inputs{}, err
+	}
+
+	waitStrategy := os.Getenv("INPUT_WAIT_STRATEGY")
+	if waitStrategy == "" {
+		waitStrategy = waitStrategyPoll
+	}
+	if waitStrategy != waitStrategyPoll && waitStrategy != waitStrategyWebhook {
+		return inputs{}, fmt.Errorf("input 'wait_strategy' must be one of '%v' or '%v'", waitStrategyPoll, waitStrategyWebhook)
+	}
+
+	webhookSecret := os.Getenv("INPUT_WEBHOOK_SECRET")
+	if waitStrategy == waitStrategyWebhook && webhookSecret == "" {
+		return inputs{}, errors.New("input 'webhook_secret' must be set when 'wait_strategy' is 'webhook'")
+	}
+
+	webhookAddress := os.Getenv("INPUT_WEBHOOK_ADDRESS")
+	if webhookAddress == "" {
+		webhookAddress = defaultWebhookAddress
+	}
+
+	webhookFallbackSeconds := int64(defaultWebhookFallbackSeconds)
+	if webhookFallbackSecondsString := os.Getenv("INPUT_WEBHOOK_FALLBACK_SECONDS"); webhookFallbackSecondsString != "" {
+		webhookFallbackSeconds, err = strconv.ParseInt(webhookFallbackSecondsString, 10, 64)
+		if err != nil || webhookFallbackSeconds < 1 {
+			return inputs{}, errors.New("input 'webhook_fallback_seconds' must be a positive integer")
+		}
+	}
+
 	workflowInputsString, ok := os.LookupEnv("INPUT_WORKFLOW_INPUTS")
 	if !ok {
 		return inputs{}, errors.New("input 'workflow_inputs' not set")
 	}
-	err = json.Unmarshal([]byte(workflowInputsString), &workflowInputs)
+	workflowInputs, matrix, err := parseWorkflowInputs(workflowInputsString)
 	if err != nil {
-		return inputs{}, fmt.Errorf("input 'workflow_inputs' is not json: %w", err)
+		return inputs{}, err
 	}
 
 	return inputs{
-		appID:              appID,
-		privateKey:         privateKey,
-		workflowFilename:   workflowFilename,
-		targetRepository:   targetRepository,
-		targetOwner:        targetOwner,
-		targetRef:          targetRef,
-		waitForCheck:       waitForCheck,
-		waitTimeoutSeconds: waitTimeoutSeconds,
-		workflowInputs:     workflowInputs,
-		installationId:     installationId,
+		credentials:            credentials,
+		baseTransport:          baseTransport,
+		uploadUrl:              os.Getenv("INPUT_UPLOAD_URL"),
+		workflowFilename:       workflowFilename,
+		checkName:              defaultCheckName(workflowFilename),
+		targetRepository:       targets[0].repository,
+		targetOwner:            targets[0].owner,
+		targets:                targets,
+		targetTopics:           targetTopics,
+		maxParallel:            maxParallel,
+		targetRef:              targetRef,
+		waitForCheck:           waitForCheck,
+		waitTimeoutSeconds:     waitTimeoutSeconds,
+		successConclusions:     successConclusions,
+		outputPrefix:           outputPrefix,
+		outputSchema:           outputSchema,
+		artifactDownloads:      artifactDownloads,
+		pollBackoff:            pollBackoff,
+		runLookupInterval:      defaultRunLookupInterval,
+		runLookupTimeout:       defaultRunLookupTimeout,
+		retryPolicy:            retryPolicy,
+		onTimeout:              onTimeout,
+		onCancel:               onCancel,
+		streamProgress:         streamProgress,
+		stepSummary:            stepSummary,
+		redactInputs:           redactInputs,
+		secretValues:           secretValues(),
+		waitStrategy:           waitStrategy,
+		webhookAddress:         webhookAddress,
+		webhookSecret:          webhookSecret,
+		webhookFallbackSeconds: webhookFallbackSeconds,
+		workflowInputs:         workflowInputs,
+		matrix:                 matrix,
 	}, nil
 }
 
+// parseTargets splits the target_repository input, which may list several
+// owner/repo-name entries separated by commas or newlines, into targets. The
+// repo-name of each entry may be a glob pattern
+func parseTargets(targetRepositories string) ([]target, error) {
+	targets := []target{}
+	for _, entry := range strings.FieldsFunc(targetRepositories, isListSeparator) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		targetOwnerRepo := strings.Split(entry, "/")
+		if len(targetOwnerRepo) != 2 || targetOwnerRepo[0] == "" || targetOwnerRepo[1] == "" {
+			return nil, fmt.Errorf("input 'target_repository' entry '%v' not formatted as owner/repo-name", entry)
+		}
+		if _, err := path.Match(targetOwnerRepo[1], ""); err != nil {
+			return nil, fmt.Errorf("input 'target_repository' entry '%v' is not a valid pattern: %w", entry, err)
+		}
+
+		targets = append(targets, target{owner: targetOwnerRepo[0], repository: targetOwnerRepo[1]})
+	}
+
+	if len(targets) == 0 {
+		return nil, errors.New("input 'target_repository' not set")
+	}
+
+	return targets, nil
+}
+
+// parsePollBackoff parses the inputs configuring the interval between polls
+// while waiting for the check
+func parsePollBackoff() (backoffPolicy, error) {
+	initialSeconds, err := parseFloatInput("poll_interval_seconds", defaultPollIntervalSeconds)
+	if err != nil || initialSeconds <= 0 {
+		return backoffPolicy{}, errors.New("input 'poll_interval_seconds' must be a positive number")
+	}
+	maxSeconds, err := parseFloatInput("poll_max_interval_seconds", defaultPollMaxIntervalSeconds)
+	if err != nil || maxSeconds < initialSeconds {
+		return backoffPolicy{}, errors.New("input 'poll_max_interval_seconds' must be a number no less than 'poll_interval_seconds'")
+	}
+	multiplier, err := parseFloatInput("poll_backoff_multiplier", defaultPollBackoffMultiplier)
+	if err != nil || multiplier < 1 {
+		return backoffPolicy{}, errors.New("input 'poll_backoff_multiplier' must be a number no less than 1")
+	}
+	jitter, err := parseFloatInput("poll_jitter", defaultPollJitter)
+	if err != nil || jitter < 0 || jitter >= 1 {
+		return backoffPolicy{}, errors.New("input 'poll_jitter' must be a number from 0 up to (but excluding) 1")
+	}
+
+	return backoffPolicy{
+		initial:    time.Duration(initialSeconds * float64(time.Second)),
+		max:        time.Duration(maxSeconds * float64(time.Second)),
+		multiplier: multiplier,
+		jitter:     jitter,
+	}, nil
+}
+
+// parseRetryPolicy parses the inputs configuring how failed api calls are
+// retried
+func parseRetryPolicy() (retryPolicy, error) {
+	maxAttempts, err := parseFloatInput("retry_max_attempts", defaultRetryMaxAttempts)
+	if err != nil || maxAttempts < 1 || maxAttempts != float64(int(maxAttempts)) {
+		return retryPolicy{}, errors.New("input 'retry_max_attempts' must be a positive integer")
+	}
+	initialDelaySeconds, err := parseFloatInput("retry_initial_delay_seconds", defaultRetryInitialDelaySeconds)
+	if err != nil || initialDelaySeconds < 0 {
+		return retryPolicy{}, errors.New("input 'retry_initial_delay_seconds' must be a non-negative number")
+	}
+	maxDelaySeconds, err := parseFloatInput("retry_max_delay_seconds", defaultRetryMaxDelaySeconds)
+	if err != nil || maxDelaySeconds < initialDelaySeconds {
+		return retryPolicy{}, errors.New("input 'retry_max_delay_seconds' must be a number no less than 'retry_initial_delay_seconds'")
+	}
+
+	return retryPolicy{
+		maxAttempts:  int(maxAttempts),
+		initialDelay: time.Duration(initialDelaySeconds * float64(time.Second)),
+		maxDelay:     time.Duration(maxDelaySeconds * float64(time.Second)),
+	}, nil
+}
+
+// checkConclusions are the conclusions a check may complete with
+var checkConclusions = []string{"action_required", "cancelled", "failure", "neutral", "success", "skipped", "stale", "timed_out"}
+
+// parseSuccessConclusions parses the success_conclusions input, which lists
+// the conclusions counting as success separated by commas or newlines
+func parseSuccessConclusions(successConclusions string) ([]string, error) {
+	conclusions := []string{}
+	for _, entry := range strings.FieldsFunc(successConclusions, isListSeparator) {
+		conclusion := strings.ToLower(strings.TrimSpace(entry))
+		if conclusion == "" {
+			continue
+		}
+		if !containsString(checkConclusions, conclusion) {
+			return nil, fmt.Errorf("input 'success_conclusions' entry '%v' is not a check conclusion, expected one of %v", entry, strings.Join(checkConclusions, ", "))
+		}
+		conclusions = append(conclusions, conclusion)
+	}
+
+	if len(conclusions) == 0 {
+		return []string{"success"}, nil
+	}
+	return conclusions, nil
+}
+
+// isSuccessConclusion reports whether a check which completed with the given
+// conclusion counts as successful
+func (inputs inputs) isSuccessConclusion(conclusion string) bool {
+	if len(inputs.successConclusions) == 0 {
+		return conclusion == "success"
+	}
+	return containsString(inputs.successConclusions, conclusion)
+}
+
+// containsString reports whether the value is one of the values
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// parseInterruptPolicy parses an input selecting how the check and target
+// workflow run are handled when the dispatch is interrupted
+func parseInterruptPolicy(name string) (string, error) {
+	policy := os.Getenv("INPUT_" + strings.ToUpper(name))
+	switch policy {
+	case "":
+		return interruptPolicyComplete, nil
+	case interruptPolicyCancel, interruptPolicyComplete, interruptPolicyIgnore:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("input '%v' must be one of '%v', '%v' or '%v'", name, interruptPolicyCancel, interruptPolicyComplete, interruptPolicyIgnore)
+	}
+}
+
+// parseFloatInput parses an optional numeric input, returning the default
+// value if it is not set
+func parseFloatInput(name string, defaultValue float64) (float64, error) {
+	value := os.Getenv("INPUT_" + strings.ToUpper(name))
+	if value == "" {
+		return defaultValue, nil
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// isListSeparator reports whether r separates entries of list inputs
+func isListSeparator(r rune) bool {
+	return r == ',' || r == '\n'
+}
+
+// parseCredentials determines how the action should authenticate with
+// GitHub. A static token (given directly or as a path to a file) takes
+// precedence over GitHub app credentials
+func parseCredentials() (credentials, error) {
+	if token := os.Getenv("INPUT_TOKEN"); token != "" {
+		return tokenCredentials{token: token}, nil
+	}
+
+	if tokenFile := os.Getenv("INPUT_TOKEN_FILE"); tokenFile != "" {
+		return tokenFileCredentials{path: tokenFile}, nil
+	}
+
+	appIDString, ok := os.LookupEnv("INPUT_APP_ID")
+	if !ok || appIDString == "" {
+		return nil, errors.New("one of inputs 'token', 'token_file' or 'app_id' must be set")
+	}
+	appID, err := strconv.ParseInt(appIDString, 10, 64)
+	if err != nil {
+		return nil, errors.New("input 'app_id' must be an integer")
+	}
+
+	installationId := int64(-1)
+	installationIdString, ok := os.LookupEnv("APP_INSTALLATION_ID")
+	if ok {
+		installationId, err = strconv.ParseInt(installationIdString, 10, 64)
+		if err != nil {
+			return nil, errors.New("APP_INSTALLATION_ID must be an integer")
+		}
+	}
+
+	privateKeyString, ok := os.LookupEnv("INPUT_PRIVATE_KEY")
+	if !ok {
+		return nil, errors.New("input 'private_key' not set")
+	}
+	block, _ := pem.Decode([]byte(privateKeyString))
+	if block == nil {
+		return nil, errors.New("input 'private_key' not a PEM block")
+	}
+	if block.Type != "RSA PRIVATE KEY" {
+		return nil, fmt.Errorf("input 'private_key' PEM block not an RSA private key. It is a %v", block.Type)
+	}
+	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("input 'private_key' RSA Private Key not formatted properly: %w", err)
+	}
+
+	return appCredentials{
+		appID:          appID,
+		privateKey:     privateKey,
+		installationId: installationId,
+	}, nil
+}
+
+// defaultCheckName derives the name of the check from the workflow_filename
+// input, dropping any directory and extension
+func defaultCheckName(workflowFilename string) string {
+	name := path.Base(workflowFilename)
+	return strings.TrimSuffix(strings.TrimSuffix(name, ".yml"), ".yaml")
+}
+
+// defaultWorkflowInputNames are the names of the inputs added to every
+// dispatch by addDefaultWorkflowInputs
+var defaultWorkflowInputNames = []string{"check_id", "github_repository", "github_sha"}
+
 // addDefaultWorkflowInputs adds a standard set of variables to the inputs
 // which will be set as part of the workflow_dispatch request. These are given
 // in addition to those specified as input by the user
-func addDefaultWorkflowInputs(inputs *inputs, githubVars githubVars, checkRun *github.CheckRun) {
+func addDefaultWorkflowInputs(inputs *inputs, githubVars githubVars, checkRun *github.CheckRun) error {
 	// Add default inputs to those provided by the user
 	inputs.workflowInputs["github_repository"] = githubVars.repository
 	inputs.workflowInputs["github_sha"] = githubVars.sha
@@ -135,7 +507,9 @@ func addDefaultWorkflowInputs(inputs *inputs, githubVars githubVars, checkRun *g
 
 	rawInputs, err := json.Marshal(inputs.workflowInputs)
 	if err != nil {
-		githubactions.Fatalf("Error unmarshaling workflow_inputs: %v", err.Error())
+		return newError(errorClassValidation, "Error marshaling workflow_inputs: %v", err.Error())
 	}
 	githubactions.Infof("Complete workflow inputs: %v\n", string(rawInputs))
+
+	return nil
 }
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSuccessConclusions(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected []string
+	}{
+		{"", []string{"success"}},
+		{"success, neutral,\nSkipped", []string{"success", "neutral", "skipped"}},
+	}
+
+	for _, c := range cases {
+		conclusions, err := parseSuccessConclusions(c.input)
+		if err != nil {
+			t.Fatalf("unexpected error parsing %q: %v", c.input, err)
+		}
+		if !reflect.DeepEqual(conclusions, c.expected) {
+			t.Errorf("expected %v for %q, got %v", c.expected, c.input, conclusions)
+		}
+	}
+
+	if _, err := parseSuccessConclusions("success,passed"); err == nil {
+		t.Error("expected an error for a conclusion which checks cannot have")
+	}
+}
@@ -0,0 +1,1075 @@
+// Package fakegithub provides an in-process fake of the subset of the GitHub
+// api used by the action, so the complete dispatch flow can be tested
+// without network access. It simulates repositories and their contents, app
+// installations, check runs whose state changes over time, workflow
+// dispatches and the runs they create, rate limits and injected failures.
+package fakegithub
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v37/github"
+)
+
+// Server is a fake GitHub api server. The zero value is not usable, see New
+type Server struct {
+	*httptest.Server
+
+	// OnDispatch, if set, is called for every workflow dispatch after the
+	// run it creates has been recorded. It is typically used to script the
+	// check and run of the dispatch, see ScriptCheckRun and ScriptWorkflowRun
+	OnDispatch func(dispatch Dispatch)
+
+	mu            sync.Mutex
+	nextID        int64
+	repositories  map[string]*Repository
+	installations []*installation
+	checkRuns     map[int64]*checkRun
+	workflowRuns  map[int64]*workflowRun
+	jobLogs       map[int64]string
+	artifacts     map[int64]*Artifact
+	dispatches    []Dispatch
+	requests      []string
+	failures      []*failure
+	rateLimit     *rateLimit
+	notModified   int
+	// runListingDelay is the number of listings of workflow runs each new
+	// run is left out of, see DelayWorkflowRuns
+	runListingDelay int
+}
+
+// Repository is a repository known to the server
+type Repository struct {
+	Owner         string
+	Name          string
+	DefaultBranch string
+	Topics        []string
+	Archived      bool
+
+	// files maps a ref to the files (by path) at that ref
+	files map[string]map[string]string
+	// workflows maps the ID of each registered workflow to its path
+	workflows map[int64]string
+}
+
+// Dispatch records a workflow_dispatch event received by the server
+type Dispatch struct {
+	Owner      string
+	Repository string
+	// Workflow is the workflow identifier given in the url, either an ID or
+	// a filename
+	Workflow string
+	Ref      string
+	Inputs   map[string]interface{}
+	// RunID is the ID of the workflow run created by the dispatch
+	RunID int64
+}
+
+// Artifact is an artifact uploaded by a workflow run, see AddArtifact
+type Artifact struct {
+	ID    int64
+	RunID int64
+	Name  string
+	// Archive is the zip archive downloaded for the artifact
+	Archive []byte
+	// Digest is listed as the digest of the archive, if not empty
+	Digest  string
+	Expired bool
+}
+
+// CheckRunUpdate is a state a scripted check run moves to
+type CheckRunUpdate struct {
+	Status     string
+	Conclusion string
+	// Text replaces the text of the output of the check, if not empty
+	Text string
+}
+
+// WorkflowRunUpdate is a state a scripted workflow run moves to
+type WorkflowRunUpdate struct {
+	Status     string
+	Conclusion string
+	// Jobs replaces the jobs of the run, if not nil
+	Jobs []*github.WorkflowJob
+}
+
+type installation struct {
+	id           int64
+	owner        string
+	repositories []string
+}
+
+type checkRun struct {
+	owner      string
+	repository string
+	run        *github.CheckRun
+	script     []CheckRunUpdate
+}
+
+type workflowRun struct {
+	owner      string
+	repository string
+	workflow   string
+	run        *github.WorkflowRun
+	// displayTitle is set as the run-name of a workflow would, and contains
+	// the check_id input if one was dispatched
+	displayTitle string
+	jobs         []*github.WorkflowJob
+	script       []WorkflowRunUpdate
+	// hiddenListings is the number of listings of workflow runs the run is
+	// still left out of
+	hiddenListings int
+}
+
+type failure struct {
+	method     string
+	pathPrefix string
+	statusCode int
+	remaining  int
+	// processed failures are returned after the request has taken effect
+	processed bool
+}
+
+type rateLimit struct {
+	limit     int
+	remaining int
+	reset     time.Time
+}
+
+// New starts a fake GitHub api server. It must be closed by the caller
+func New() *Server {
+	s := newServer()
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// NewTLS starts a fake GitHub api server serving HTTPS with a self-signed
+// certificate (see Certificate). It must be closed by the caller
+func NewTLS() *Server {
+	s := newServer()
+	s.Server = httptest.NewTLSServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func newServer() *Server {
+	return &Server{
+		nextID:       1000,
+		repositories: map[string]*Repository{},
+		checkRuns:    map[int64]*checkRun{},
+		workflowRuns: map[int64]*workflowRun{},
+		jobLogs:      map[int64]string{},
+		artifacts:    map[int64]*Artifact{},
+	}
+}
+
+// Client returns a go-github client which sends its requests to the server
+func (s *Server) Client() *github.Client {
+	return s.ClientWithTransport(s.Server.Client().Transport)
+}
+
+// ClientWithTransport returns a go-github client which sends its requests
+// to the server through the given transport
+func (s *Server) ClientWithTransport(transport http.RoundTripper) *github.Client {
+	client := github.NewClient(&http.Client{Transport: transport})
+	baseUrl, _ := url.Parse(s.URL + "/")
+	client.BaseURL = baseUrl
+	client.UploadURL = baseUrl
+	return client
+}
+
+// AddRepository adds a repository with the given default branch and topics
+func (s *Server) AddRepository(owner, name, defaultBranch string, topics ...string) *Repository {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	repository := &Repository{
+		Owner:         owner,
+		Name:          name,
+		DefaultBranch: defaultBranch,
+		Topics:        topics,
+		files:         map[string]map[string]string{},
+		workflows:     map[int64]string{},
+	}
+	s.repositories[repositoryKey(owner, name)] = repository
+	return repository
+}
+
+// SetFile sets the content of a file in a repository at a ref
+func (s *Server) SetFile(owner, name, ref, filepath, content string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	repository := s.repositories[repositoryKey(owner, name)]
+	if repository.files[ref] == nil {
+		repository.files[ref] = map[string]string{}
+	}
+	repository.files[ref][filepath] = content
+}
+
+// AddWorkflow adds a workflow file to the default branch of a repository,
+// registering it with the given ID
+func (s *Server) AddWorkflow(owner, name, filepath, content string, id int64) {
+	repository := s.repository(owner, name)
+	s.SetFile(owner, name, repository.DefaultBranch, filepath, content)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	repository.workflows[id] = filepath
+}
+
+// AddInstallation adds an installation of the app for an owner, with access
+// to the given repositories (or all of the repositories of the owner if none
+// are given)
+func (s *Server) AddInstallation(id int64, owner string, repositories ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.installations = append(s.installations, &installation{id: id, owner: owner, repositories: repositories})
+}
+
+// ScriptCheckRun sets the states a check run moves through: each request
+// fetching the check run applies the next update before responding
+func (s *Server) ScriptCheckRun(id int64, updates ...CheckRunUpdate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checkRuns[id].script = append(s.checkRuns[id].script, updates...)
+}
+
+// ScriptWorkflowRun sets the states a workflow run moves through: each
+// request fetching the run applies the next update before responding
+func (s *Server) ScriptWorkflowRun(id int64, updates ...WorkflowRunUpdate) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.workflowRuns[id].script = append(s.workflowRuns[id].script, updates...)
+}
+
+// AddWorkflowRun adds a workflow_dispatch run of a workflow with the given
+// display title, as if dispatched by someone else, returning its ID
+func (s *Server) AddWorkflowRun(owner, name string, workflowId int64, displayTitle string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := s.newID()
+	now := time.Now()
+	s.workflowRuns[id] = &workflowRun{
+		owner:      owner,
+		repository: name,
+		workflow:   fmt.Sprint(workflowId),
+		run: &github.WorkflowRun{
+			ID:         github.Int64(id),
+			WorkflowID: github.Int64(workflowId),
+			Event:      github.String("workflow_dispatch"),
+			Status:     github.String("queued"),
+			HTMLURL:    github.String(fmt.Sprintf("%v/%v/%v/actions/runs/%d", s.URL, owner, name, id)),
+			CreatedAt:  &github.Timestamp{Time: now},
+			UpdatedAt:  &github.Timestamp{Time: now},
+		},
+		displayTitle: displayTitle,
+	}
+	return id
+}
+
+// SetWorkflowRunTitle sets the display title of a workflow run, as a
+// run-name without the check_id input would
+func (s *Server) SetWorkflowRunTitle(id int64, displayTitle string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.workflowRuns[id].displayTitle = displayTitle
+}
+
+// DelayWorkflowRuns leaves the runs created by later dispatches out of the
+// first count listings of workflow runs, as GitHub takes a while to list a
+// new run
+func (s *Server) DelayWorkflowRuns(count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.runListingDelay = count
+}
+
+// SetJobLog sets the log of a job, which is downloaded from the url the
+// logs endpoint of the job redirects to
+func (s *Server) SetJobLog(id int64, log string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.jobLogs[id] = log
+}
+
+// AddArtifact adds an artifact to the workflow run given by its RunID,
+// returning its ID. The archive is downloaded from the url the download
+// endpoint of the artifact redirects to
+func (s *Server) AddArtifact(artifact Artifact) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if artifact.ID == 0 {
+		artifact.ID = s.newID()
+	}
+	s.artifacts[artifact.ID] = &artifact
+	return artifact.ID
+}
+
+// CheckRun returns the current state of a check run, or nil if it does not exist
+func (s *Server) CheckRun(id int64) *github.CheckRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if check, ok := s.checkRuns[id]; ok {
+		copied := *check.run
+		return &copied
+	}
+	return nil
+}
+
+// WorkflowRun returns a copy of the workflow run with the given ID, or nil
+// if there is none
+func (s *Server) WorkflowRun(id int64) *github.WorkflowRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if run, ok := s.workflowRuns[id]; ok {
+		copied := *run.run
+		return &copied
+	}
+	return nil
+}
+
+// Dispatches returns the workflow dispatches received so far
+func (s *Server) Dispatches() []Dispatch {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Dispatch{}, s.dispatches...)
+}
+
+// Requests returns the requests received so far, as "METHOD /path"
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.requests...)
+}
+
+// NotModifiedCount returns the number of requests answered with 304 Not
+// Modified so far
+func (s *Server) NotModifiedCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.notModified
+}
+
+// FailRequests makes the next count requests with the given method and a
+// path starting with pathPrefix fail with the given status code
+func (s *Server) FailRequests(method, pathPrefix string, statusCode, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = append(s.failures, &failure{method: method, pathPrefix: pathPrefix, statusCode: statusCode, remaining: count})
+}
+
+// FailResponses makes the next count requests with the given method and a
+// path starting with pathPrefix fail with the given status code after they
+// have been processed, as when a response is lost after the request took
+// effect
+func (s *Server) FailResponses(method, pathPrefix string, statusCode, count int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures = append(s.failures, &failure{method: method, pathPrefix: pathPrefix, statusCode: statusCode, remaining: count, processed: true})
+}
+
+// SetRateLimit limits the number of requests the server accepts until the
+// reset time. Once the limit is exhausted, requests are rejected the way
+// GitHub rejects them, with a 403 and a remaining limit of 0
+func (s *Server) SetRateLimit(remaining int, reset time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.rateLimit = &rateLimit{limit: remaining, remaining: remaining, reset: reset}
+}
+
+func (s *Server) repository(owner, name string) *Repository {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.repositories[repositoryKey(owner, name)]
+}
+
+func repositoryKey(owner, name string) string {
+	return strings.ToLower(owner + "/" + name)
+}
+
+func (s *Server) newID() int64 {
+	s.nextID++
+	return s.nextID
+}
+
+// handle applies rate limits and injected failures to a request, then
+// routes it to the handler of its endpoint. Responses to GET requests carry
+// an ETag, and requests whose If-None-Match matches it are answered with
+// 304 Not Modified, which like GitHub does not count against the rate limit
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, fmt.Sprintf("%v %v", r.Method, r.URL.Path))
+
+	if s.rateLimit != nil && time.Now().After(s.rateLimit.reset) {
+		s.rateLimit.remaining = s.rateLimit.limit
+	}
+	if s.rateLimit != nil && s.rateLimit.remaining == 0 {
+		s.writeRateLimitHeaders(w)
+		writeError(w, http.StatusForbidden, "API rate limit exceeded")
+		return
+	}
+
+	for _, f := range s.failures {
+		if f.remaining > 0 && f.method == r.Method && strings.HasPrefix(r.URL.Path, f.pathPrefix) {
+			f.remaining--
+			if f.processed {
+				s.route(httptest.NewRecorder(), r)
+			}
+			s.consumeRateLimit(w)
+			writeError(w, f.statusCode, http.StatusText(f.statusCode))
+			return
+		}
+	}
+
+	if r.Method != http.MethodGet {
+		s.consumeRateLimit(w)
+		s.route(w, r)
+		return
+	}
+
+	recorder := httptest.NewRecorder()
+	s.route(recorder, r)
+
+	etag := fmt.Sprintf(`"%x"`, sha256.Sum256(recorder.Body.Bytes()))
+	if recorder.Code == http.StatusOK && r.Header.Get("If-None-Match") == etag {
+		s.notModified++
+		s.writeRateLimitHeaders(w)
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	s.consumeRateLimit(w)
+	for name, values := range recorder.Header() {
+		w.Header()[name] = values
+	}
+	if recorder.Code == http.StatusOK {
+		w.Header().Set("ETag", etag)
+	}
+	w.WriteHeader(recorder.Code)
+	_, _ = w.Write(recorder.Body.Bytes())
+}
+
+// consumeRateLimit counts a request against the rate limit, if one is set
+func (s *Server) consumeRateLimit(w http.ResponseWriter) {
+	if s.rateLimit != nil {
+		s.rateLimit.remaining--
+	}
+	s.writeRateLimitHeaders(w)
+}
+
+func (s *Server) writeRateLimitHeaders(w http.ResponseWriter) {
+	if s.rateLimit == nil {
+		return
+	}
+	w.Header().Set("X-RateLimit-Limit", fmt.Sprint(s.rateLimit.limit))
+	w.Header().Set("X-RateLimit-Remaining", fmt.Sprint(s.rateLimit.remaining))
+	w.Header().Set("X-RateLimit-Reset", fmt.Sprint(s.rateLimit.reset.Unix()))
+}
+
+// route passes a request to the handler of its endpoint
+func (s *Server) route(w http.ResponseWriter, r *http.Request) {
+	// Like GitHub Enterprise Server, the api is also served under /api/v3
+	segments := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v3"), "/"), "/")
+	switch {
+	case match(segments, "app", "installations", "*") && r.Method == http.MethodGet:
+		s.getInstallation(w, segments[2])
+	case match(segments, "app", "installations", "*", "access_tokens") && r.Method == http.MethodPost:
+		s.createAccessToken(w, segments[2])
+	case match(segments, "orgs", "*", "installation"), match(segments, "users", "*", "installation"):
+		s.findInstallation(w, segments[1], "")
+	case match(segments, "repos", "*", "*", "installation"):
+		s.findInstallation(w, segments[1], segments[2])
+	case match(segments, "orgs", "*", "repos"), match(segments, "users", "*", "repos"):
+		s.listRepositories(w, segments[0], segments[1])
+	case match(segments, "_logs", "*") && r.Method == http.MethodGet:
+		s.downloadJobLog(w, segments[1])
+	case match(segments, "_artifacts", "*") && r.Method == http.MethodGet:
+		s.downloadArtifactArchive(w, segments[1])
+	case len(segments) >= 3 && segments[0] == "repos":
+		repository, ok := s.repositories[repositoryKey(segments[1], segments[2])]
+		if !ok {
+			writeError(w, http.StatusNotFound, "Not Found")
+			return
+		}
+		s.handleRepository(w, r, repository, segments[3:])
+	default:
+		writeError(w, http.StatusNotFound, "Not Found")
+	}
+}
+
+// handleRepository routes a request for an endpoint under /repos/{owner}/{repo}
+func (s *Server) handleRepository(w http.ResponseWriter, r *http.Request, repository *Repository, segments []string) {
+	switch {
+	case len(segments) == 0 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, s.repositoryJSON(repository))
+	case len(segments) > 1 && segments[0] == "contents" && r.Method == http.MethodGet:
+		s.getContents(w, r, repository, strings.Join(segments[1:], "/"))
+	case match(segments, "check-runs") && r.Method == http.MethodPost:
+		s.createCheckRun(w, r, repository)
+	case match(segments, "check-runs", "*") && r.Method == http.MethodGet:
+		s.getCheckRun(w, repository, segments[1])
+	case match(segments, "check-runs", "*") && r.Method == http.MethodPatch:
+		s.updateCheckRun(w, r, repository, segments[1])
+	case match(segments, "commits", "*", "check-runs") && r.Method == http.MethodGet:
+		s.listCheckRunsForRef(w, r, repository, segments[1])
+	case match(segments, "actions", "workflows", "*") && r.Method == http.MethodGet:
+		s.getWorkflow(w, repository, segments[2])
+	case match(segments, "actions", "workflows", "*", "dispatches") && r.Method == http.MethodPost:
+		s.createDispatch(w, r, repository, segments[2])
+	case match(segments, "actions", "workflows", "*", "runs") && r.Method == http.MethodGet:
+		s.listWorkflowRuns(w, r, repository, segments[2])
+	case match(segments, "actions", "runs", "*") && r.Method == http.MethodGet:
+		s.getWorkflowRun(w, repository, segments[2])
+	case match(segments, "actions", "runs", "*", "cancel") && r.Method == http.MethodPost:
+		s.cancelWorkflowRun(w, repository, segments[2])
+	case match(segments, "actions", "runs", "*", "jobs") && r.Method == http.MethodGet:
+		s.listWorkflowJobs(w, repository, segments[2])
+	case match(segments, "actions", "jobs", "*", "logs") && r.Method == http.MethodGet:
+		s.getJobLogs(w, segments[2])
+	case match(segments, "actions", "runs", "*", "artifacts") && r.Method == http.MethodGet:
+		s.listWorkflowRunArtifacts(w, repository, segments[2])
+	case match(segments, "actions", "artifacts", "*", "zip") && r.Method == http.MethodGet:
+		s.getArtifactDownload(w, segments[2])
+	default:
+		writeError(w, http.StatusNotFound, "Not Found")
+	}
+}
+
+// match reports whether the segments of a path match a pattern, in which
+// "*" matches any single segment
+func match(segments []string, pattern ...string) bool {
+	if len(segments) != len(pattern) {
+		return false
+	}
+	for i, segment := range pattern {
+		if segment != "*" && segment != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) getInstallation(w http.ResponseWriter, rawId string) {
+	for _, installation := range s.installations {
+		if fmt.Sprint(installation.id) == rawId {
+			writeJSON(w, http.StatusOK, installationJSON(installation))
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "Not Found")
+}
+
+func (s *Server) createAccessToken(w http.ResponseWriter, rawId string) {
+	for _, installation := range s.installations {
+		if fmt.Sprint(installation.id) == rawId {
+			expiresAt := time.Now().Add(time.Hour)
+			writeJSON(w, http.StatusCreated, &github.InstallationToken{
+				Token:     github.String(fmt.Sprintf("ghs_installation_%d", installation.id)),
+				ExpiresAt: &expiresAt,
+			})
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "Not Found")
+}
+
+func (s *Server) findInstallation(w http.ResponseWriter, owner, repository string) {
+	for _, installation := range s.installations {
+		if !strings.EqualFold(installation.owner, owner) {
+			continue
+		}
+		if repository == "" || len(installation.repositories) == 0 {
+			writeJSON(w, http.StatusOK, installationJSON(installation))
+			return
+		}
+		for _, name := range installation.repositories {
+			if strings.EqualFold(name, repository) {
+				writeJSON(w, http.StatusOK, installationJSON(installation))
+				return
+			}
+		}
+	}
+	writeError(w, http.StatusNotFound, "Not Found")
+}
+
+func installationJSON(installation *installation) *github.Installation {
+	return &github.Installation{
+		ID:      github.Int64(installation.id),
+		Account: &github.User{Login: github.String(installation.owner)},
+	}
+}
+
+func (s *Server) listRepositories(w http.ResponseWriter, kind, owner string) {
+	repositories := []*github.Repository{}
+	for _, repository := range s.repositories {
+		if strings.EqualFold(repository.Owner, owner) {
+			repositories = append(repositories, s.repositoryJSON(repository))
+		}
+	}
+	if len(repositories) == 0 && kind == "orgs" {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	writeJSON(w, http.StatusOK, repositories)
+}
+
+func (s *Server) repositoryJSON(repository *Repository) *github.Repository {
+	return &github.Repository{
+		Name:          github.String(repository.Name),
+		FullName:      github.String(repository.Owner + "/" + repository.Name),
+		Owner:         &github.User{Login: github.String(repository.Owner)},
+		DefaultBranch: github.String(repository.DefaultBranch),
+		Topics:        repository.Topics,
+		Archived:      github.Bool(repository.Archived),
+	}
+}
+
+func (s *Server) getContents(w http.ResponseWriter, r *http.Request, repository *Repository, filepath string) {
+	ref := r.URL.Query().Get("ref")
+	if ref == "" {
+		ref = repository.DefaultBranch
+	}
+
+	content, ok := repository.files[ref][filepath]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &github.RepositoryContent{
+		Type:     github.String("file"),
+		Name:     github.String(path.Base(filepath)),
+		Path:     github.String(filepath),
+		Encoding: github.String("base64"),
+		Content:  github.String(base64.StdEncoding.EncodeToString([]byte(content))),
+	})
+}
+
+func (s *Server) createCheckRun(w http.ResponseWriter, r *http.Request, repository *Repository) {
+	opts := github.CreateCheckRunOptions{}
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	id := s.newID()
+	run := &github.CheckRun{
+		ID:         github.Int64(id),
+		Name:       github.String(opts.Name),
+		HeadSHA:    github.String(opts.HeadSHA),
+		DetailsURL: opts.DetailsURL,
+		HTMLURL:    github.String(fmt.Sprintf("%v/%v/%v/runs/%d", s.URL, repository.Owner, repository.Name, id)),
+		Status:     github.String("queued"),
+		ExternalID: opts.ExternalID,
+		StartedAt:  opts.StartedAt,
+		Output:     opts.Output,
+	}
+	if opts.Status != nil {
+		run.Status = opts.Status
+	}
+	s.checkRuns[id] = &checkRun{owner: repository.Owner, repository: repository.Name, run: run}
+
+	writeJSON(w, http.StatusCreated, run)
+}
+
+func (s *Server) listCheckRunsForRef(w http.ResponseWriter, r *http.Request, repository *Repository, ref string) {
+	name := r.URL.Query().Get("check_name")
+	runs := []*github.CheckRun{}
+	for _, check := range s.checkRuns {
+		if repositoryKey(check.owner, check.repository) != repositoryKey(repository.Owner, repository.Name) || check.run.GetHeadSHA() != ref {
+			continue
+		}
+		if name != "" && check.run.GetName() != name {
+			continue
+		}
+		runs = append(runs, check.run)
+	}
+
+	writeJSON(w, http.StatusOK, &github.ListCheckRunsResults{
+		Total:     github.Int(len(runs)),
+		CheckRuns: runs,
+	})
+}
+
+func (s *Server) findCheckRun(w http.ResponseWriter, repository *Repository, rawId string) *checkRun {
+	id, _ := strconv.ParseInt(rawId, 10, 64)
+	check, ok := s.checkRuns[id]
+	if !ok || repositoryKey(check.owner, check.repository) != repositoryKey(repository.Owner, repository.Name) {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return nil
+	}
+	return check
+}
+
+func (s *Server) getCheckRun(w http.ResponseWriter, repository *Repository, rawId string) {
+	check := s.findCheckRun(w, repository, rawId)
+	if check == nil {
+		return
+	}
+
+	if len(check.script) > 0 {
+		update := check.script[0]
+		check.script = check.script[1:]
+		check.run.Status = github.String(update.Status)
+		if update.Conclusion != "" {
+			check.run.Conclusion = github.String(update.Conclusion)
+		}
+		if update.Status == "completed" && check.run.CompletedAt == nil {
+			check.run.CompletedAt = &github.Timestamp{Time: time.Now()}
+		}
+		if update.Text != "" {
+			if check.run.Output == nil {
+				check.run.Output = &github.CheckRunOutput{}
+			}
+			check.run.Output.Text = github.String(update.Text)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, check.run)
+}
+
+func (s *Server) updateCheckRun(w http.ResponseWriter, r *http.Request, repository *Repository, rawId string) {
+	check := s.findCheckRun(w, repository, rawId)
+	if check == nil {
+		return
+	}
+
+	opts := github.UpdateCheckRunOptions{}
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	check.run.Name = github.String(opts.Name)
+	if opts.DetailsURL != nil {
+		check.run.DetailsURL = opts.DetailsURL
+	}
+	if opts.Status != nil {
+		check.run.Status = opts.Status
+	}
+	if opts.Conclusion != nil {
+		check.run.Conclusion = opts.Conclusion
+		check.run.Status = github.String("completed")
+	}
+	if opts.CompletedAt != nil {
+		check.run.CompletedAt = opts.CompletedAt
+	}
+	if opts.Output != nil {
+		check.run.Output = opts.Output
+	}
+
+	writeJSON(w, http.StatusOK, check.run)
+}
+
+// findWorkflow returns the path of the workflow with the given identifier,
+// which may be an ID or a filename, as registered on the default branch
+func findWorkflow(repository *Repository, identifier string) (int64, string, bool) {
+	for id, filepath := range repository.workflows {
+		if fmt.Sprint(id) == identifier || path.Base(filepath) == identifier {
+			return id, filepath, true
+		}
+	}
+	return 0, "", false
+}
+
+func (s *Server) getWorkflow(w http.ResponseWriter, repository *Repository, identifier string) {
+	id, filepath, ok := findWorkflow(repository, identifier)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &github.Workflow{
+		ID:    github.Int64(id),
+		Name:  github.String(path.Base(filepath)),
+		Path:  github.String(filepath),
+		State: github.String("active"),
+	})
+}
+
+func (s *Server) createDispatch(w http.ResponseWriter, r *http.Request, repository *Repository, identifier string) {
+	workflowId, _, ok := findWorkflow(repository, identifier)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	event := github.CreateWorkflowDispatchEventRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if _, ok := repository.files[event.Ref]; !ok {
+		writeError(w, http.StatusUnprocessableEntity, fmt.Sprintf("No ref found for: %v", event.Ref))
+		return
+	}
+
+	id := s.newID()
+	now := time.Now()
+	run := &workflowRun{
+		owner:      repository.Owner,
+		repository: repository.Name,
+		workflow:   fmt.Sprint(workflowId),
+		run: &github.WorkflowRun{
+			ID:         github.Int64(id),
+			WorkflowID: github.Int64(workflowId),
+			Event:      github.String("workflow_dispatch"),
+			HeadBranch: github.String(event.Ref),
+			Status:     github.String("queued"),
+			HTMLURL:    github.String(fmt.Sprintf("%v/%v/%v/actions/runs/%d", s.URL, repository.Owner, repository.Name, id)),
+			CreatedAt:  &github.Timestamp{Time: now},
+			UpdatedAt:  &github.Timestamp{Time: now},
+		},
+		displayTitle:   fmt.Sprintf("%v [%v]", identifier, event.Inputs["check_id"]),
+		hiddenListings: s.runListingDelay,
+	}
+	s.workflowRuns[id] = run
+
+	dispatch := Dispatch{
+		Owner:      repository.Owner,
+		Repository: repository.Name,
+		Workflow:   identifier,
+		Ref:        event.Ref,
+		Inputs:     event.Inputs,
+		RunID:      id,
+	}
+	s.dispatches = append(s.dispatches, dispatch)
+
+	w.WriteHeader(http.StatusNoContent)
+
+	if s.OnDispatch != nil {
+		// The hook may script the check and run, which requires the lock
+		s.mu.Unlock()
+		s.OnDispatch(dispatch)
+		s.mu.Lock()
+	}
+}
+
+// workflowRunJSON adds the display title to the go-github representation
+// of a workflow run, which does not include it
+func workflowRunJSON(run *workflowRun) interface{} {
+	return struct {
+		*github.WorkflowRun
+		DisplayTitle string `json:"display_title"`
+	}{run.run, run.displayTitle}
+}
+
+func (s *Server) listWorkflowRuns(w http.ResponseWriter, r *http.Request, repository *Repository, identifier string) {
+	workflowId, _, ok := findWorkflow(repository, identifier)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	event := r.URL.Query().Get("event")
+	runs := []interface{}{}
+	for _, run := range s.workflowRuns {
+		if repositoryKey(run.owner, run.repository) != repositoryKey(repository.Owner, repository.Name) || run.workflow != fmt.Sprint(workflowId) {
+			continue
+		}
+		if event != "" && run.run.GetEvent() != event {
+			continue
+		}
+		if run.hiddenListings > 0 {
+			run.hiddenListings--
+			continue
+		}
+		runs = append(runs, workflowRunJSON(run))
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"total_count":   len(runs),
+		"workflow_runs": runs,
+	})
+}
+
+func (s *Server) getWorkflowRun(w http.ResponseWriter, repository *Repository, rawId string) {
+	id, _ := strconv.ParseInt(rawId, 10, 64)
+	run, ok := s.workflowRuns[id]
+	if !ok || repositoryKey(run.owner, run.repository) != repositoryKey(repository.Owner, repository.Name) {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	if len(run.script) > 0 {
+		update := run.script[0]
+		run.script = run.script[1:]
+		run.run.Status = github.String(update.Status)
+		if update.Conclusion != "" {
+			run.run.Conclusion = github.String(update.Conclusion)
+		}
+		if update.Jobs != nil {
+			run.jobs = update.Jobs
+		}
+		run.run.UpdatedAt = &github.Timestamp{Time: time.Now()}
+	}
+
+	writeJSON(w, http.StatusOK, workflowRunJSON(run))
+}
+
+// cancelWorkflowRun cancels a run immediately, discarding its script. Like
+// GitHub, runs which have already completed cannot be cancelled
+func (s *Server) cancelWorkflowRun(w http.ResponseWriter, repository *Repository, rawId string) {
+	id, _ := strconv.ParseInt(rawId, 10, 64)
+	run, ok := s.workflowRuns[id]
+	if !ok || repositoryKey(run.owner, run.repository) != repositoryKey(repository.Owner, repository.Name) {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	if run.run.GetStatus() == "completed" {
+		writeError(w, http.StatusConflict, "Cannot cancel a workflow run that is completed.")
+		return
+	}
+
+	run.script = nil
+	run.run.Status = github.String("completed")
+	run.run.Conclusion = github.String("cancelled")
+	run.run.UpdatedAt = &github.Timestamp{Time: time.Now()}
+
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{})
+}
+
+func (s *Server) listWorkflowJobs(w http.ResponseWriter, repository *Repository, rawId string) {
+	id, _ := strconv.ParseInt(rawId, 10, 64)
+	run, ok := s.workflowRuns[id]
+	if !ok || repositoryKey(run.owner, run.repository) != repositoryKey(repository.Owner, repository.Name) {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &github.Jobs{
+		TotalCount: github.Int(len(run.jobs)),
+		Jobs:       run.jobs,
+	})
+}
+
+// getJobLogs redirects to the download of the log of a job, like GitHub
+// redirects to a pre-authorized url
+func (s *Server) getJobLogs(w http.ResponseWriter, rawId string) {
+	id, _ := strconv.ParseInt(rawId, 10, 64)
+	if _, ok := s.jobLogs[id]; !ok {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("%v/_logs/%d", s.URL, id))
+	w.WriteHeader(http.StatusFound)
+}
+
+func (s *Server) downloadJobLog(w http.ResponseWriter, rawId string) {
+	id, _ := strconv.ParseInt(rawId, 10, 64)
+	log, ok := s.jobLogs[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(log))
+}
+
+func (s *Server) listWorkflowRunArtifacts(w http.ResponseWriter, repository *Repository, rawId string) {
+	id, _ := strconv.ParseInt(rawId, 10, 64)
+	run, ok := s.workflowRuns[id]
+	if !ok || repositoryKey(run.owner, run.repository) != repositoryKey(repository.Owner, repository.Name) {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	type artifactJSON struct {
+		*github.Artifact
+		Digest string `json:"digest,omitempty"`
+	}
+	artifacts := []artifactJSON{}
+	for _, artifact := range s.artifacts {
+		if artifact.RunID != id {
+			continue
+		}
+		artifacts = append(artifacts, artifactJSON{&github.Artifact{
+			ID:          github.Int64(artifact.ID),
+			Name:        github.String(artifact.Name),
+			SizeInBytes: github.Int64(int64(len(artifact.Archive))),
+			Expired:     github.Bool(artifact.Expired),
+		}, artifact.Digest})
+	}
+	sort.Slice(artifacts, func(i, j int) bool {
+		return artifacts[i].GetID() < artifacts[j].GetID()
+	})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"total_count": len(artifacts),
+		"artifacts":   artifacts,
+	})
+}
+
+// getArtifactDownload redirects to the download of the archive of an
+// artifact, like GitHub redirects to a pre-authorized url
+func (s *Server) getArtifactDownload(w http.ResponseWriter, rawId string) {
+	id, _ := strconv.ParseInt(rawId, 10, 64)
+	artifact, ok := s.artifacts[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+	if artifact.Expired {
+		writeError(w, http.StatusGone, "Artifact has expired")
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("%v/_artifacts/%d", s.URL, id))
+	w.WriteHeader(http.StatusFound)
+}
+
+func (s *Server) downloadArtifactArchive(w http.ResponseWriter, rawId string) {
+	id, _ := strconv.ParseInt(rawId, 10, 64)
+	artifact, ok := s.artifacts[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not Found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	_, _ = w.Write(artifact.Archive)
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, message string) {
+	writeJSON(w, statusCode, map[string]string{"message": message})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/go-github/v37/github"
+	"github.com/sethvargo/go-githubactions"
+)
+
+const (
+	// interruptPolicyCancel cancels the target workflow run and concludes
+	// the check
+	interruptPolicyCancel = "cancel"
+	// interruptPolicyComplete concludes the check, leaving the target
+	// workflow run running
+	interruptPolicyComplete = "complete"
+	// interruptPolicyIgnore leaves both the check and the target workflow
+	// run alone
+	interruptPolicyIgnore = "ignore"
+
+	// interruptCleanupTimeout bounds the requests made after an
+	// interruption. The runner kills the action shortly after cancelling it
+	interruptCleanupTimeout = time.Second * 5
+)
+
+// errCheckTimedOut is the cause of errors returned once waiting for the
+// check has taken longer than wait_timeout_seconds
+var errCheckTimedOut = errors.New("Timed out waiting for the check")
+
+// interruption describes why a dispatch ended before its check completed
+// and how the check and target workflow run are handled as a result
+type interruption struct {
+	// policy is one of the interruptPolicy constants
+	policy string
+	// conclusion is the conclusion given to the check
+	conclusion string
+	reason     string
+}
+
+// interruptionOf reports whether a dispatch which failed with the given
+// error was interrupted, either by the cancellation of the job (which
+// cancels the context) or by timing out while waiting for the check
+func (inputs inputs) interruptionOf(ctx context.Context, err error) (interruption, bool) {
+	if ctx.Err() != nil {
+		return interruption{
+			policy:     inputs.onCancel,
+			conclusion: "cancelled",
+			reason:     "The job dispatching the workflow was cancelled",
+		}, true
+	}
+
+	if errors.Is(err, errCheckTimedOut) {
+		return interruption{
+			policy:     inputs.onTimeout,
+			conclusion: "timed_out",
+			reason:     fmt.Sprintf("The check did not complete within %v seconds", inputs.waitTimeoutSeconds),
+		}, true
+	}
+
+	return interruption{}, false
+}
+
+// handleInterruption applies the policy of an interruption to the check and
+// (if it was identified exactly) the target workflow run of the dispatch,
+// and returns the conclusion given to the check, if any
+func handleInterruption(client *GitHubClient, checkRun *github.CheckRun, result dispatchResult, interrupt interruption) string {
+	// The context of the dispatch may already be cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), interruptCleanupTimeout)
+	defer cancel()
+
+	githubactions.Warningf("%v, handling check %v with policy '%v'", interrupt.reason, checkRun.GetID(), interrupt.policy)
+
+	summary := interrupt.reason
+	switch interrupt.policy {
+	case interruptPolicyIgnore:
+		return ""
+	case interruptPolicyCancel:
+		if result.RunId == 0 {
+			githubactions.Warningf("The workflow run created by the dispatch was not identified, so it cannot be cancelled")
+			break
+		}
+		// Only a run known to belong to the dispatch is cancelled, never
+		// one merely assumed to, see FindDispatchedWorkflowRun
+		if !result.runVerified {
+			githubactions.Warningf("Workflow run %v was not matched by the check_id of the dispatch, so it is not cancelled", result.RunId)
+			break
+		}
+		err := client.CancelWorkflowRun(ctx, result.RunId)
+		if err != nil {
+			githubactions.Warningf("%v", err.Error())
+			break
+		}
+		summary = fmt.Sprintf("%v, so the triggered workflow run was cancelled", interrupt.reason)
+	}
+
+	summary += describeRunLink(result.RunUrl)
+	err := client.CompleteCheck(ctx, withRunDetails(checkRun, result.RunUrl), interrupt.conclusion, summary, "")
+	if err != nil {
+		githubactions.Warningf("%v", err.Error())
+		return ""
+	}
+	return interrupt.conclusion
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DrizlyInc/workflow-dispatch-action/action/m/v2/internal/fakegithub"
+)
+
+func TestInterruptionPolicies(t *testing.T) {
+	cases := []struct {
+		name string
+		// cancelled cancels the job once waiting for the check, instead of
+		// waiting until the timeout
+		cancelled          bool
+		policy             string
+		expectedConclusion string
+		expectedRunStatus  string
+		// unverified leaves the check_id out of the title of the run, so
+		// it is only assumed to be the dispatched run
+		unverified bool
+	}{
+		{"timeout cancels run", false, interruptPolicyCancel, "timed_out", "completed", false},
+		{"timeout completes check", false, interruptPolicyComplete, "timed_out", "queued", false},
+		{"timeout ignored", false, interruptPolicyIgnore, "", "queued", false},
+		{"cancel cancels run", true, interruptPolicyCancel, "cancelled", "completed", false},
+		{"cancel completes check", true, interruptPolicyComplete, "cancelled", "queued", false},
+		{"cancel leaves unverified run", true, interruptPolicyCancel, "cancelled", "queued", true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server, client := newTestClient(t)
+			server.OnDispatch = func(dispatch fakegithub.Dispatch) {
+				server.ScriptCheckRun(dispatchedCheckId(t, dispatch), fakegithub.CheckRunUpdate{Status: "in_progress"})
+				if c.unverified {
+					server.SetWorkflowRunTitle(dispatch.RunID, "deploy")
+				}
+			}
+			client.inputs.waitTimeoutSeconds = 1
+			client.inputs.onTimeout = c.policy
+			client.inputs.onCancel = c.policy
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if c.cancelled {
+				go func() {
+					// Cancel once the check is being polled
+					for deadline := time.Now().Add(time.Second * 5); time.Now().Before(deadline); {
+						for _, request := range server.Requests() {
+							if strings.HasPrefix(request, "GET /repos/source-owner/source/check-runs/") {
+								cancel()
+								return
+							}
+						}
+						time.Sleep(time.Millisecond * 10)
+					}
+				}()
+			}
+
+			result := dispatchWithClient(ctx, client)
+			if result.Succeeded {
+				t.Fatal("expected the dispatch to fail")
+			}
+			if !c.cancelled && errorClassOf(result.err) != errorClassTimeout {
+				t.Errorf("expected a timeout error, got %v: %v", errorClassOf(result.err), result.err)
+			}
+
+			check := server.CheckRun(result.CheckId)
+			if check.GetConclusion() != c.expectedConclusion {
+				t.Errorf("expected the check to conclude %q, got %q", c.expectedConclusion, check.GetConclusion())
+			}
+			if c.expectedConclusion != "" && !strings.Contains(check.GetOutput().GetSummary(), result.RunUrl) {
+				t.Errorf("expected the summary of the check to link to the run, got %q", check.GetOutput().GetSummary())
+			}
+			if c.expectedConclusion == "" && check.GetStatus() != "in_progress" {
+				t.Errorf("expected the check to be left in progress, got %v", check.GetStatus())
+			}
+			if result.runVerified == c.unverified {
+				t.Errorf("expected the run to be verified=%v", !c.unverified)
+			}
+			if run := server.WorkflowRun(result.RunId); run.GetStatus() != c.expectedRunStatus {
+				t.Errorf("expected the run to be %v, got %v", c.expectedRunStatus, run.GetStatus())
+			}
+		})
+	}
+}
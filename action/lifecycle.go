@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// maxStepSummariesLength bounds the length of the step summaries gathered
+// for the text of a check, which GitHub limits to 65535 characters in total
+const maxStepSummariesLength = 32 * 1024
+
+// jobStatusConclusions maps the status of a job (job.status) to the
+// conclusion of the check finishing it
+var jobStatusConclusions = map[string]string{
+	"success":   "success",
+	"failure":   "failure",
+	"cancelled": "cancelled",
+}
+
+// parseLifecycleReport parses the inputs of the start and finish modes.
+// Starting moves the check to in_progress with the workflow run running the
+// action as its details. Finishing completes the check with the conclusion
+// corresponding to the 'job_status' input, appending the summaries listed
+// in the 'step_summary_files' input to its text. Neither updates a check which has
+// already been completed
+func parseLifecycleReport(mode string, githubVars githubVars) (checkReport, error) {
+	checkId, err := parseCheckId()
+	if err != nil {
+		return checkReport{}, err
+	}
+
+	report := checkReport{
+		checkId:       checkId,
+		summary:       os.Getenv("INPUT_CHECK_SUMMARY"),
+		text:          os.Getenv("INPUT_CHECK_TEXT"),
+		keepCompleted: true,
+	}
+
+	if mode == modeStart {
+		runId := os.Getenv("GITHUB_RUN_ID")
+		if runId == "" {
+			return checkReport{}, errors.New("GITHUB_RUN_ID env var not set")
+		}
+		report.status = "in_progress"
+		report.detailsUrl = fmt.Sprintf("%v/%v/actions/runs/%v", githubVars.serverUrl, githubVars.repository, runId)
+		return report, nil
+	}
+
+	jobStatus := strings.TrimSpace(os.Getenv("INPUT_JOB_STATUS"))
+	conclusion, ok := jobStatusConclusions[jobStatus]
+	if !ok {
+		return checkReport{}, errors.New("input 'job_status' must be the status of the job (success, failure or cancelled), e.g. ${{ job.status }}")
+	}
+
+	outputs, err := parseCheckOutputsInput()
+	if err != nil {
+		return checkReport{}, err
+	}
+
+	summaries, err := gatherStepSummaries()
+	if err != nil {
+		return checkReport{}, err
+	}
+
+	report.status = "completed"
+	report.conclusion = conclusion
+	report.outputs = outputs
+	if summaries != "" {
+		report.text = strings.TrimSpace(summaries + "\n\n" + report.text)
+	}
+	return report, nil
+}
+
+// gatherStepSummaries returns the contents of the files listed in the
+// 'step_summary_files' input, one path per line, in the order they are
+// listed. Every file listed must be readable, so that a summary is never
+// silently left out
+func gatherStepSummaries() (string, error) {
+	gathered := []string{}
+	length := 0
+	for _, path := range strings.Split(os.Getenv("INPUT_STEP_SUMMARY_FILES"), "\n") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("unable to read step summary listed in input 'step_summary_files': %w", err)
+		}
+		text := strings.TrimSpace(string(contents))
+		if text == "" {
+			continue
+		}
+		if length+len(text) > maxStepSummariesLength {
+			gathered = append(gathered, "_Further step summaries were omitted, see the workflow run._")
+			break
+		}
+		gathered = append(gathered, text)
+		length += len(text)
+	}
+	return strings.Join(gathered, "\n\n"), nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLifecycleReports(t *testing.T) {
+	server, client := newTestClient(t)
+	ctx := context.Background()
+
+	checkRun, err := client.CreateCheck(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error creating the check: %v", err)
+	}
+
+	os.Setenv("INPUT_CHECK_ID", fmt.Sprint(checkRun.GetID()))
+	os.Setenv("GITHUB_RUN_ID", "42")
+	defer os.Unsetenv("INPUT_CHECK_ID")
+	defer os.Unsetenv("GITHUB_RUN_ID")
+	defer os.Unsetenv("INPUT_JOB_STATUS")
+
+	receivingVars := githubVars{repository: "target-owner/target", serverUrl: "https://github.example.com"}
+	start, err := parseLifecycleReport(modeStart, receivingVars)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = reportCheck(ctx, client, start)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	check := server.CheckRun(checkRun.GetID())
+	if check.GetStatus() != "in_progress" || check.GetDetailsURL() != "https://github.example.com/target-owner/target/actions/runs/42" {
+		t.Errorf("expected the check to be started with the run as its details, got %v: %v", check.GetStatus(), check.GetDetailsURL())
+	}
+
+	// A check finished once is not finished again
+	for _, jobStatus := range []string{"failure", "success"} {
+		os.Setenv("INPUT_JOB_STATUS", jobStatus)
+		finish, err := parseLifecycleReport(modeFinish, receivingVars)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err = reportCheck(ctx, client, finish)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	check = server.CheckRun(checkRun.GetID())
+	if check.GetStatus() != "completed" || check.GetConclusion() != "failure" {
+		t.Errorf("expected the check to be finished once as failure, got %v", describeCheckState(check))
+	}
+
+	os.Setenv("INPUT_JOB_STATUS", "skipped")
+	if _, err := parseLifecycleReport(modeFinish, receivingVars); err == nil {
+		t.Error("expected an error for a job status with no conclusion")
+	}
+}
+
+func TestGatherStepSummaries(t *testing.T) {
+	directory := t.TempDir()
+	write := func(name, contents string) string {
+		path := filepath.Join(directory, name)
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	build := write("build.md", "## Build\n")
+	empty := write("empty.md", "")
+	deploy := write("deploy.md", "## Deploy\n")
+	defer os.Unsetenv("INPUT_STEP_SUMMARY_FILES")
+
+	os.Setenv("INPUT_STEP_SUMMARY_FILES", "")
+	if summaries, err := gatherStepSummaries(); err != nil || summaries != "" {
+		t.Errorf("expected no summaries without the input, got %q, %v", summaries, err)
+	}
+
+	os.Setenv("INPUT_STEP_SUMMARY_FILES", strings.Join([]string{deploy, empty, "", build}, "\n"))
+	expected := "## Deploy\n\n## Build"
+	if summaries, err := gatherStepSummaries(); err != nil || summaries != expected {
+		t.Errorf("expected the summaries in the order listed, got %q, %v", summaries, err)
+	}
+
+	os.Setenv("INPUT_STEP_SUMMARY_FILES", build+"\n"+filepath.Join(directory, "missing.md"))
+	if _, err := gatherStepSummaries(); err == nil {
+		t.Error("expected an error for a summary which cannot be read")
+	}
+}
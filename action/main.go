@@ -2,80 +2,669 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/google/go-github/v37/github"
 	"github.com/sethvargo/go-githubactions"
 )
 
+// dispatchResult records the outcome of dispatching the workflow to a
+// single target repository
+type dispatchResult struct {
+	Repository string `json:"repository"`
+	CheckId    int64  `json:"check_id"`
+	CheckUrl   string `json:"check_url"`
+	RunId      int64  `json:"run_id,omitempty"`
+	RunUrl     string `json:"run_url,omitempty"`
+	// Status, Conclusion and the timestamps describe the check as last
+	// fetched, see recordCheck
+	Status          string     `json:"status,omitempty"`
+	Conclusion      string     `json:"conclusion,omitempty"`
+	StartedAt       *time.Time `json:"started_at,omitempty"`
+	CompletedAt     *time.Time `json:"completed_at,omitempty"`
+	DurationSeconds *int64     `json:"duration_seconds,omitempty"`
+	// Matrix holds the inputs specific to this dispatch when a matrix of
+	// workflow inputs was given
+	Matrix    map[string]interface{} `json:"matrix,omitempty"`
+	Succeeded bool                   `json:"succeeded"`
+	Output    json.RawMessage        `json:"output,omitempty"`
+	// Artifacts are those downloaded from the workflow run, see
+	// downloadRunArtifacts
+	Artifacts []downloadedArtifact `json:"artifacts,omitempty"`
+	Error     string               `json:"error,omitempty"`
+
+	// err is the error which caused the dispatch to fail, if any
+	err error
+	// runVerified is set when the workflow run was identified by its
+	// correlation token, see FindDispatchedWorkflowRun
+	runVerified bool
+	// rawOutput is the unparsed output scraped from the check report
+	rawOutput string
+	// dispatchedAt is when the workflow was dispatched, and summary the
+	// details of the dispatch reported in the job summary, see
+	// recordSummary
+	dispatchedAt time.Time
+	summary      *dispatchSummary
+}
+
 func main() {
-	client := initializeGithubClient()
+	mode, err := parseMode()
+	if err != nil {
+		exitWithError(err)
+	}
+
+	// The runner signals the action when the job is cancelled, which
+	// cancels every dispatch in progress, see interruptionOf
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch mode {
+	case modeReport, modeStart, modeFinish:
+		runReport(ctx, mode)
+	default:
+		runDispatch(ctx)
+	}
+}
+
+// runDispatch dispatches the workflow to every target and sets the outputs
+// of the action with the results
+func runDispatch(ctx context.Context) {
+	githubVars, inputs, err := parseEnvironment()
+	if err != nil {
+		exitWithError(err)
+	}
 
-	client.ValidateTargetWorkflowExists(context.Background())
+	results, err := performDispatches(ctx, githubVars, inputs)
+	if err != nil {
+		exitWithError(err)
+	}
+	logRateBudgets()
+	if inputs.stepSummary {
+		writeDispatchSummary(results)
+	}
 
-	checkRun := client.CreateCheck(context.Background())
+	switch {
+	case inputs.isFanOut():
+		reportResults(results, inputs.isMatrix())
+	case inputs.isMatrix():
+		reportMatrixResults(results)
+	default:
+		reportResult(results[0], inputs.outputPrefix)
+	}
+}
 
-	client.DispatchWorkflow(context.Background(), checkRun)
+// performDispatches performs every dispatch of the inputs, closing the
+// waiter of the checks once all of them are done
+func performDispatches(ctx context.Context, githubVars githubVars, inputs inputs) ([]dispatchResult, error) {
+	if inputs.waitForCheck {
+		waiter, err := newWaiter(inputs)
+		if err != nil {
+			return nil, err
+		}
+		defer waiter.Close()
+		inputs.waiter = waiter
+	}
+
+	if !inputs.isFanOut() && !inputs.isMatrix() {
+		return []dispatchResult{dispatchToTarget(ctx, githubVars, inputs)}, nil
+	}
+
+	targets := inputs.targets
+	if inputs.isFanOut() {
+		var err error
+		targets, err = expandTargets(ctx, githubVars, inputs)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	waitForCheckCompletion(client, checkRun)
+	return dispatchAll(ctx, githubVars, expandDispatches(inputs, targets))
 }
 
-// initializeGithubClient parses environment variables (user inputs and
-// standard GitHub variables) then uses those to construct and return
-// a GitHub api client
-func initializeGithubClient() *GitHubClient {
+// parseEnvironment parses environment variables (user inputs and
+// standard GitHub variables)
+func parseEnvironment() (githubVars, inputs, error) {
 	githubVars, err := parseGithubVars()
 	if err != nil {
-		githubactions.Fatalf("%v", err.Error())
+		return githubVars, inputs{}, newError(errorClassValidation, "%v", err.Error())
 	}
 
 	inputs, err := parseInputs()
 	if err != nil {
-		githubactions.Fatalf("%v", err.Error())
+		return githubVars, inputs, newError(errorClassValidation, "%v", err.Error())
 	}
 
-	return NewGitHubClient(githubVars, inputs)
+	return githubVars, inputs, nil
 }
 
-// waitForCheckCompletion waits for the given checkRun to update to a status
-// of "completed" with a timeout specified as input by the user
-func waitForCheckCompletion(client *GitHubClient, checkRun *github.CheckRun) {
+// exitWithError reports an error and exits with the code corresponding to
+// its class. This is the only place the action exits due to an error
+func exitWithError(err error) {
+	class := errorClassOf(err)
+	githubactions.Errorf("%v", err.Error())
+	githubactions.Debugf("Exiting with code %d (%v error)", class.exitCode(), class)
+	os.Exit(class.exitCode())
+}
+
+// expandDispatches scopes the inputs to each dispatch which should be
+// performed: one per target repository and matrix entry. Each dispatch
+// downloads artifacts into a directory of its own
+func expandDispatches(baseInputs inputs, targets []target) []inputs {
+	if baseInputs.artifactDownloads != nil {
+		perRun := *baseInputs.artifactDownloads
+		perRun.perRun = true
+		baseInputs.artifactDownloads = &perRun
+	}
+
+	baseInputs.multipleDispatches = true
+
+	dispatches := []inputs{}
+	for _, t := range targets {
+		targetInputs := baseInputs.forTarget(t)
+		if !baseInputs.isMatrix() {
+			dispatches = append(dispatches, targetInputs)
+			continue
+		}
+		for _, entry := range baseInputs.matrix {
+			dispatches = append(dispatches, targetInputs.forMatrixEntry(entry))
+		}
+	}
+	return dispatches
+}
+
+// dispatchAll creates a client for every dispatch and performs them, see
+// dispatchWithClients. The dispatches to a repository share the api
+// clients authorized against it, so that each installation token is only
+// requested once
+func dispatchAll(ctx context.Context, githubVars githubVars, dispatches []inputs) ([]dispatchResult, error) {
+	repositoryClients := map[string]*GitHubClient{}
+	clients := make([]*GitHubClient, len(dispatches))
+	for i, dispatchInputs := range dispatches {
+		repository := fmt.Sprintf("%v/%v", dispatchInputs.targetOwner, dispatchInputs.targetRepository)
+		client, ok := repositoryClients[strings.ToLower(repository)]
+		if !ok {
+			var err error
+			client, err = NewGitHubClient(githubVars, dispatchInputs)
+			if err != nil {
+				return nil, fmt.Errorf("%v: %w", describeDispatch(repository, dispatchInputs.matrixEntry), err)
+			}
+			repositoryClients[strings.ToLower(repository)] = client
+		}
+		clients[i] = client.withInputs(dispatchInputs)
+	}
+
+	return dispatchWithClients(ctx, clients)
+}
+
+// dispatchWithClients validates every dispatch up front, so that none is
+// performed unless all of them can be, then performs them concurrently,
+// bounded by the max_parallel input. The results are returned in the same
+// order as the clients
+func dispatchWithClients(ctx context.Context, clients []*GitHubClient) ([]dispatchResult, error) {
+	err := validateDispatches(ctx, clients)
+	if err != nil {
+		return nil, err
+	}
+
+	maxParallel := clients[0].inputs.maxParallel
+	githubactions.Infof("Performing %d dispatches (%d at a time)\n", len(clients), maxParallel)
+
+	results := make([]dispatchResult, len(clients))
+	semaphore := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+
+	for i, client := range clients {
+		wg.Add(1)
+		go func(i int, client *GitHubClient) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			results[i] = dispatchWithClient(ctx, client)
+		}(i, client)
+	}
+
+	wg.Wait()
+	return results, nil
+}
+
+// validateDispatches validates the target workflow and inputs of every
+// dispatch, returning an error describing each invalid dispatch if any.
+// The class of the error is that of the first invalid dispatch
+func validateDispatches(ctx context.Context, clients []*GitHubClient) error {
+	problems := []string{}
+	var firstErr error
+	for _, client := range clients {
+		err := validateDispatch(ctx, client)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			description := describeDispatch(fmt.Sprintf("%v/%v", client.inputs.targetOwner, client.inputs.targetRepository), client.inputs.matrixEntry)
+			problems = append(problems, fmt.Sprintf("%v: %v", description, err.Error()))
+		}
+	}
+
+	if len(problems) > 0 {
+		return &classifiedError{
+			class: errorClassOf(firstErr),
+			err:   fmt.Errorf("%d of %d dispatches are invalid, so none were performed:\n%v", len(problems), len(clients), strings.Join(problems, "\n")),
+		}
+	}
+
+	return nil
+}
+
+// describeDispatch names a dispatch by its target repository and, when a
+// matrix of inputs was given, its matrix entry
+func describeDispatch(repository string, matrixEntry map[string]interface{}) string {
+	if matrixEntry == nil {
+		return repository
+	}
+	return fmt.Sprintf("%v (%v)", repository, describeMatrixEntry(matrixEntry))
+}
+
+// dispatchToTarget performs a dispatch to the single target repository of
+// the inputs. Any error ending the dispatch after its check was created
+// completes the check as failed, so it is never left queued, unless the
+// dispatch was interrupted, see handleInterruption
+func dispatchToTarget(ctx context.Context, githubVars githubVars, inputs inputs) dispatchResult {
+	result := dispatchResult{
+		Repository: fmt.Sprintf("%v/%v", inputs.targetOwner, inputs.targetRepository),
+		Matrix:     inputs.matrixEntry,
+	}
+
+	client, err := NewGitHubClient(githubVars, inputs)
+	if err != nil {
+		result.err = err
+		result.Error = err.Error()
+		if inputs.stepSummary {
+			result.summary = newDispatchSummary(inputs, "")
+		}
+		return result
+	}
+
+	return dispatchWithClient(ctx, client)
+}
+
+// dispatchWithClient performs a dispatch to the single target repository of
+// the client, see dispatchToTarget
+func dispatchWithClient(ctx context.Context, client *GitHubClient) dispatchResult {
+	result := dispatchResult{
+		Repository: fmt.Sprintf("%v/%v", client.inputs.targetOwner, client.inputs.targetRepository),
+		Matrix:     client.inputs.matrixEntry,
+	}
+
+	checkRun, err := performDispatch(ctx, client, &result)
+	if err != nil {
+		if interrupt, interrupted := client.inputs.interruptionOf(ctx, err); interrupted && checkRun != nil {
+			result.Conclusion = handleInterruption(client, checkRun, result, interrupt)
+		} else if checkRun != nil {
+			result.Conclusion = finalizeCheck(client, checkRun, result, err)
+		}
+		result.err = err
+		result.Error = err.Error()
+	} else {
+		result.Succeeded = true
+	}
+
+	if checkRun != nil {
+		recordCheck(client, &result)
+	}
+	if client.inputs.stepSummary {
+		recordSummary(client, &result)
+	}
+	return result
+}
+
+// recordCheck fetches the check of the dispatch once it has finished (or
+// been abandoned) to record its final state in the result. A check which
+// cannot be fetched keeps the state recorded during the dispatch
+func recordCheck(client *GitHubClient, result *dispatchResult) {
+	// The context of the dispatch may already be cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), interruptCleanupTimeout)
+	defer cancel()
+
+	check, err := client.FetchCheck(ctx, client.githubVars, result.CheckId)
+	if err != nil {
+		githubactions.Warningf("Error fetching the final state of check %v: %v", result.CheckId, err.Error())
+		return
+	}
+
+	result.Status = check.GetStatus()
+	if check.Conclusion != nil {
+		result.Conclusion = check.GetConclusion()
+	}
+	if check.StartedAt != nil {
+		result.StartedAt = &check.StartedAt.Time
+	}
+	if check.CompletedAt != nil {
+		result.CompletedAt = &check.CompletedAt.Time
+	}
+	if result.StartedAt != nil && result.CompletedAt != nil {
+		duration := int64(result.CompletedAt.Sub(*result.StartedAt).Round(time.Second).Seconds())
+		result.DurationSeconds = &duration
+	}
+}
+
+// performDispatch performs the complete flow against the single target
+// repository of the client: validating the workflow, creating a check,
+// dispatching the workflow and (optionally) waiting for the check. The
+// check is returned once created, even if an error occurs afterwards
+func performDispatch(ctx context.Context, client *GitHubClient, result *dispatchResult) (*github.CheckRun, error) {
+	var err error
+	if !client.validated {
+		err = validateDispatch(ctx, client)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Refuse to dispatch if the rate limit cannot cover waiting for the
+	// check, rather than failing part way through the wait
+	if client.inputs.waitForCheck {
+		err = checkWaitBudget(client, time.Second*time.Duration(client.inputs.waitTimeoutSeconds))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	checkRun, err := client.CreateCheck(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result.CheckId = checkRun.GetID()
+	result.CheckUrl = checkRun.GetHTMLURL()
+
+	dispatchedAt := time.Now()
+	result.dispatchedAt = dispatchedAt
+	err = client.DispatchWorkflow(ctx, checkRun)
+	if err != nil {
+		return checkRun, err
+	}
+
+	run, verified := findWorkflowRun(ctx, client, checkRun, dispatchedAt)
+	if run != nil {
+		result.RunId = run.GetID()
+		result.RunUrl = run.GetHTMLURL()
+		result.runVerified = verified
+	}
+
 	if !client.inputs.waitForCheck {
 		githubactions.Infof("wait_for_check was false, proceeding\n")
-		return
+		return checkRun, nil
+	}
+
+	conclusion, err := waitForCheckCompletion(ctx, client, checkRun, run, result.runVerified)
+	if err != nil {
+		return checkRun, fmt.Errorf("Error waiting for check to finish: %w", err)
+	}
+	result.Conclusion = conclusion
+
+	if !client.inputs.isSuccessConclusion(conclusion) {
+		return checkRun, newError(errorClassFailure, "Check failed with conclusion %v!", conclusion)
+	}
+
+	githubactions.Infof("Check completed successfully for %v!\n", result.Repository)
+
+	check, rawOutput, err := scrapeOutputs(client, *checkRun.ID)
+	if err != nil {
+		return checkRun, err
+	}
+	result.rawOutput = rawOutput
+	if client.inputs.outputSchema != nil {
+		violations := client.inputs.outputSchema.validate(result.rawOutput)
+		if len(violations) > 0 {
+			rejectOutput(ctx, client, check, violations)
+			return checkRun, outputSchemaError(violations)
+		}
+	}
+	if result.rawOutput != "" {
+		result.Output = json.RawMessage(result.rawOutput)
 	}
 
-	checkTimeoutCtx, cancel := context.WithTimeout(context.Background(), time.Second*time.Duration(client.inputs.waitTimeoutSeconds))
+	if client.inputs.artifactDownloads != nil {
+		artifacts, err := downloadRunArtifacts(ctx, client, run)
+		if err != nil {
+			return checkRun, fmt.Errorf("Error downloading artifacts: %w", err)
+		}
+		result.Artifacts = artifacts
+	}
+
+	return checkRun, nil
+}
+
+// validateDispatch resolves the target workflow of the client and validates
+// that it exists and declares the inputs which will be dispatched
+func validateDispatch(ctx context.Context, client *GitHubClient) error {
+	err := client.ResolveTargetWorkflow(ctx)
+	if err != nil {
+		return err
+	}
+	err = client.ValidateTargetWorkflowExists(ctx)
+	if err != nil {
+		return err
+	}
+	err = client.ValidateTargetWorkflowInputs(ctx)
+	if err != nil {
+		return err
+	}
+
+	client.validated = true
+	return nil
+}
+
+// finalizeCheck concludes the check with the error which ended the
+// dispatch, unless the check has already been completed, and returns the
+// conclusion of the check. The conclusion depends on the class of the
+// error, see checkConclusion
+func finalizeCheck(client *GitHubClient, checkRun *github.CheckRun, result dispatchResult, cause error) string {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
 	defer cancel()
 
-	checkSucceeded, err := pollForCheckCompletion(checkTimeoutCtx, client, *checkRun.ID)
+	check, err := client.FetchCheck(ctx, client.githubVars, checkRun.GetID())
+	if err == nil && check.GetStatus() == "completed" {
+		return check.GetConclusion()
+	}
+
+	class := errorClassOf(cause)
+	summary := fmt.Sprintf("The dispatch to %v failed: %v%v", result.Repository, cause.Error(), describeRunLink(result.RunUrl))
+	err = client.CompleteCheck(ctx, withRunDetails(checkRun, result.RunUrl), class.checkConclusion(), summary, class.remedy())
+	if err != nil {
+		githubactions.Warningf("%v", err.Error())
+		return ""
+	}
+	return class.checkConclusion()
+}
+
+// describeRunLink links to the triggered workflow run, if it was identified,
+// for the summary of a check
+func describeRunLink(runUrl string) string {
+	if runUrl == "" {
+		return ""
+	}
+	return fmt.Sprintf("\n\nSee the [triggered workflow run](%v) for details.", runUrl)
+}
+
+// reportResult sets the outputs of the action for a dispatch to a single
+// target repository, exiting with an error if the dispatch failed. Each
+// top-level key of a JSON output is also set as an output of its own, named
+// with the given prefix
+func reportResult(result dispatchResult, outputPrefix string) {
+	outputs := map[string]string{}
+	if result.CheckId != 0 {
+		outputs["check_id"] = fmt.Sprint(result.CheckId)
+		outputs["check_url"] = result.CheckUrl
+		outputs["status"] = result.Status
+		outputs["conclusion"] = result.Conclusion
+	}
+	if result.StartedAt != nil {
+		outputs["started_at"] = result.StartedAt.UTC().Format(time.RFC3339)
+	}
+	if result.CompletedAt != nil {
+		outputs["completed_at"] = result.CompletedAt.UTC().Format(time.RFC3339)
+	}
+	if result.DurationSeconds != nil {
+		outputs["duration_seconds"] = fmt.Sprint(*result.DurationSeconds)
+	}
+	if result.RunId != 0 {
+		outputs["run_id"] = fmt.Sprint(result.RunId)
+		outputs["run_url"] = result.RunUrl
+		outputs["target_run_id"] = fmt.Sprint(result.RunId)
+		outputs["target_run_url"] = result.RunUrl
+	}
+	setOutputs(outputs)
+
+	if result.err != nil {
+		exitWithError(result.err)
+	}
+
+	setOutput("output", result.rawOutput)
+	setOutputs(promoteOutputs(result.Output, outputPrefix))
+	if result.Artifacts != nil {
+		setArtifactOutputs(result.Artifacts)
+	}
+}
+
+// reportResults sets the outputs of the action for a dispatch to multiple
+// target repositories, exiting with an error if any of the dispatches failed.
+// When a matrix of inputs was given, the results for each repository are
+// grouped into an array
+func reportResults(results []dispatchResult, grouped bool) {
+	resultsByRepository := map[string]interface{}{}
+	for _, result := range results {
+		if !grouped {
+			resultsByRepository[result.Repository] = result
+			continue
+		}
+		repositoryResults, _ := resultsByRepository[result.Repository].([]dispatchResult)
+		resultsByRepository[result.Repository] = append(repositoryResults, result)
+	}
+
+	rawResults, err := json.Marshal(resultsByRepository)
+	if err != nil {
+		exitWithError(fmt.Errorf("Error marshaling results: %w", err))
+	}
+	setOutput("results", string(rawResults))
+
+	failIfAnyFailed(results)
+}
+
+// reportMatrixResults sets the outputs of the action for a matrix of
+// dispatches to a single target repository, exiting with an error if any of
+// the dispatches failed. The output is an array with the result of each
+// matrix entry, in the order the entries were given
+func reportMatrixResults(results []dispatchResult) {
+	rawResults, err := json.Marshal(results)
+	if err != nil {
+		exitWithError(fmt.Errorf("Error marshaling results: %w", err))
+	}
+	setOutput("output", string(rawResults))
+
+	failIfAnyFailed(results)
+}
+
+// failIfAnyFailed logs the error of every failed dispatch and exits with an
+// error if there were any. The exit code corresponds to the class of the
+// error of the first failed dispatch
+func failIfAnyFailed(results []dispatchResult) {
+	failed := []string{}
+	var firstErr error
+	for _, result := range results {
+		if result.err != nil {
+			if firstErr == nil {
+				firstErr = result.err
+			}
+			description := describeDispatch(result.Repository, result.Matrix)
+			failed = append(failed, description)
+			githubactions.Errorf("%v: %v", description, result.Error)
+		}
+	}
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		exitWithError(&classifiedError{
+			class: errorClassOf(firstErr),
+			err:   fmt.Errorf("%d of %d dispatches failed: %v", len(failed), len(results), failed),
+		})
+	}
+
+	githubactions.Infof("All %d dispatches succeeded!\n", len(results))
+}
+
+// findWorkflowRun identifies the workflow run created by the dispatch, and
+// reports whether it was verified, see FindDispatchedWorkflowRun. A run
+// which cannot be identified is not an error, since the check alone is
+// enough to track the dispatch
+func findWorkflowRun(ctx context.Context, client *GitHubClient, checkRun *github.CheckRun, dispatchedAt time.Time) (*github.WorkflowRun, bool) {
+	run, verified, err := client.FindDispatchedWorkflowRun(ctx, checkRun, dispatchedAt)
 	if err != nil {
-		githubactions.Fatalf("Error waiting for check to finish: %v", err.Error())
+		githubactions.Warningf("%v", err.Error())
+		return nil, false
 	}
 
-	if !checkSucceeded {
-		githubactions.Fatalf("Check failed!\n")
+	return run, verified
+}
+
+// waitForCheckCompletion waits for the given checkRun to update to a status
+// of "completed" with a timeout specified as input by the user, returning
+// the conclusion of the check. An error caused by the timeout wraps
+// errCheckTimedOut
+func waitForCheckCompletion(ctx context.Context, client *GitHubClient, checkRun *github.CheckRun, run *github.WorkflowRun, runVerified bool) (string, error) {
+	checkTimeoutCtx, cancel := context.WithTimeout(ctx, time.Second*time.Duration(client.inputs.waitTimeoutSeconds))
+	defer cancel()
+
+	conclusion, err := client.inputs.checkWaiter().Wait(checkTimeoutCtx, client, *checkRun.ID, run, runVerified)
+	if err != nil && ctx.Err() == nil && errors.Is(checkTimeoutCtx.Err(), context.DeadlineExceeded) {
+		return "", &classifiedError{class: errorClassTimeout, err: fmt.Errorf("%w after %vs: %v", errCheckTimedOut, client.inputs.waitTimeoutSeconds, err.Error())}
 	}
+	return conclusion, err
+}
 
-	githubactions.Infof("Check completed successfully!\n")
-	scrapeOutputs(client, *checkRun.ID)
+// rejectOutput fails the check of a dispatch whose outputs do not match the
+// output schema, annotating it with the violations found. The check has
+// already been completed by the receiving workflow, so its summary is kept
+// after a description of the failure
+func rejectOutput(ctx context.Context, client *GitHubClient, check *github.CheckRun, violations []outputSchemaViolation) {
+	summary := fmt.Sprintf("The workflow completed, but its output does not match the output_schema given by %v. See the annotations for details.", client.githubVars.repository)
+	if previousSummary := check.GetOutput().GetSummary(); previousSummary != "" {
+		summary = fmt.Sprintf("%v\n\n%v", summary, previousSummary)
+	}
+	err := client.AnnotateCheck(ctx, check, "failure", summary, client.inputs.outputSchema.annotations(violations))
+	if err != nil {
+		githubactions.Warningf("%v", err.Error())
+	}
 }
 
 // scrapeOutputs fetches the check from the repository and reads the report
-// to get any outputs written as json to the end of the report. It then sets
-// that json content as an output named "output" for this action
-func scrapeOutputs(client *GitHubClient, checkId int64) {
+// to get any outputs written to it, see parseCheckOutputs. The check is
+// returned as fetched. Outputs which cannot be parsed are a failure of the
+// receiving workflow, unlike an error fetching the check
+func scrapeOutputs(client *GitHubClient, checkId int64) (*github.CheckRun, string, error) {
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
 	defer cancel()
 
 	check, err := client.FetchCheckWithRetries(ctx, checkId)
 	if err != nil {
-		githubactions.Fatalf("Error fetching check for output scraping: %v", err.Error())
+		return nil, "", fmt.Errorf("Error fetching check for output scraping: %w", err)
 	}
 
 	checkReportText := check.GetOutput().Text
-	parsedOutputs := parseOutputsFromText(checkReportText)
-
-	githubactions.SetOutput("output", parsedOutputs)
+	rawOutput, err := parseCheckOutputs(checkReportText)
+	if err != nil {
+		// The check was fetched, but the receiving workflow reported
+		// outputs which cannot be read
+		return check, "", newError(errorClassFailure, "%v", err.Error())
+	}
+	return check, rawOutput, nil
 }
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DrizlyInc/workflow-dispatch-action/action/m/v2/internal/fakegithub"
+)
+
+const testWorkflow = `
+run-name: deploy [${{ inputs.check_id }}]
+on:
+  workflow_dispatch:
+    inputs:
+      environment:
+        required: true
+      check_id:
+      github_repository:
+      github_sha:
+`
+
+// newTestClient starts a fake GitHub server with a source repository and a
+// target repository containing the test workflow, and returns a client for
+// dispatching to it
+func newTestClient(t *testing.T) (*fakegithub.Server, *GitHubClient) {
+	server := fakegithub.New()
+	t.Cleanup(server.Close)
+
+	server.AddRepository("source-owner", "source", "main")
+	server.AddRepository("target-owner", "target", "main")
+	server.AddWorkflow("target-owner", "target", ".github/workflows/deploy.yml", testWorkflow, 42)
+
+	api := newGithubApi(server.Client())
+	client := &GitHubClient{
+		api:                api,
+		sourceApi:          api,
+		apiTimeoutDuration: time.Second * 10,
+		githubVars: githubVars{
+			repository:      "source-owner/source",
+			repositoryOwner: "source-owner",
+			repositoryName:  "source",
+			sha:             "abc123",
+			serverUrl:       server.URL,
+		},
+		inputs: inputs{
+			targetOwner:        "target-owner",
+			targetRepository:   "target",
+			targets:            []target{{owner: "target-owner", repository: "target"}},
+			targetRef:          "main",
+			workflowFilename:   "deploy",
+			checkName:          "deploy",
+			waitForCheck:       true,
+			waitTimeoutSeconds: 10,
+			workflowInputs:     map[string]interface{}{"environment": "staging"},
+			pollBackoff:        backoffPolicy{initial: time.Millisecond * 10, max: time.Millisecond * 10, multiplier: 1},
+			runLookupInterval:  time.Millisecond * 10,
+			runLookupTimeout:   time.Millisecond * 500,
+			retryPolicy:        retryPolicy{maxAttempts: 3, initialDelay: time.Millisecond, maxDelay: time.Millisecond * 10},
+		},
+	}
+
+	return server, client
+}
+
+func TestDispatchEndToEnd(t *testing.T) {
+	server, client := newTestClient(t)
+
+	server.OnDispatch = func(dispatch fakegithub.Dispatch) {
+		checkId := dispatchedCheckId(t, dispatch)
+		server.ScriptCheckRun(checkId,
+			fakegithub.CheckRunUpdate{Status: "queued"},
+			fakegithub.CheckRunUpdate{Status: "in_progress"},
+			fakegithub.CheckRunUpdate{
+				Status:     "completed",
+				Conclusion: "success",
+				Text:       "Deployed!\n```json " + outputsStartIndicator + "\n{\"version\": \"1.2.3\"}\n```",
+			},
+		)
+	}
+
+	result := dispatchWithClient(context.Background(), client)
+	if !result.Succeeded {
+		t.Fatalf("expected the dispatch to succeed, got error: %v", result.err)
+	}
+
+	dispatches := server.Dispatches()
+	if len(dispatches) != 1 {
+		t.Fatalf("expected 1 dispatch, got %d", len(dispatches))
+	}
+	if dispatches[0].Inputs["environment"] != "staging" || dispatches[0].Inputs["github_sha"] != "abc123" {
+		t.Errorf("unexpected inputs dispatched: %v", dispatches[0].Inputs)
+	}
+
+	if result.RunId != dispatches[0].RunID {
+		t.Errorf("expected run %d to be identified, got %d", dispatches[0].RunID, result.RunId)
+	}
+	if result.rawOutput != `{"version": "1.2.3"}` {
+		t.Errorf("unexpected output scraped: %q", result.rawOutput)
+	}
+	if result.Status != "completed" || result.Conclusion != "success" || result.StartedAt == nil || result.CompletedAt == nil || result.DurationSeconds == nil {
+		t.Errorf("expected the final state of the check to be recorded, got %+v", result)
+	}
+}
+
+func TestDispatchRunCompletesWithoutCheck(t *testing.T) {
+	server, client := newTestClient(t)
+
+	server.OnDispatch = func(dispatch fakegithub.Dispatch) {
+		server.ScriptWorkflowRun(dispatch.RunID,
+			fakegithub.WorkflowRunUpdate{Status: "completed", Conclusion: "failure"},
+		)
+	}
+
+	result := dispatchWithClient(context.Background(), client)
+	if result.Succeeded {
+		t.Fatal("expected the dispatch to fail")
+	}
+
+	check := server.CheckRun(result.CheckId)
+	if check.GetStatus() != "completed" || check.GetConclusion() != "failure" {
+		t.Errorf("expected the check to be concluded from the run, got %v/%v", check.GetStatus(), check.GetConclusion())
+	}
+}
+
+func TestDispatchUnverifiedRunDoesNotConcludeCheck(t *testing.T) {
+	server, client := newTestClient(t)
+	client.inputs.waitTimeoutSeconds = 1
+
+	server.OnDispatch = func(dispatch fakegithub.Dispatch) {
+		server.SetWorkflowRunTitle(dispatch.RunID, "deploy")
+		server.ScriptWorkflowRun(dispatch.RunID,
+			fakegithub.WorkflowRunUpdate{Status: "completed", Conclusion: "failure"},
+		)
+	}
+
+	result := dispatchWithClient(context.Background(), client)
+	if result.RunId == 0 || result.runVerified {
+		t.Fatalf("expected the run to be identified unverified, got run %v (verified=%v)", result.RunId, result.runVerified)
+	}
+	if errorClassOf(result.err) != errorClassTimeout {
+		t.Errorf("expected waiting for the check to time out, got %v", result.err)
+	}
+	if check := server.CheckRun(result.CheckId); check.GetConclusion() == "failure" {
+		t.Errorf("expected the check not to be concluded from an unverified run")
+	}
+}
+
+func TestDispatchSuccessConclusions(t *testing.T) {
+	cases := []struct {
+		successConclusions []string
+		succeeded          bool
+	}{
+		{nil, false},
+		{[]string{"success", "skipped"}, true},
+	}
+
+	for _, c := range cases {
+		server, client := newTestClient(t)
+		server.OnDispatch = func(dispatch fakegithub.Dispatch) {
+			server.ScriptCheckRun(dispatchedCheckId(t, dispatch), fakegithub.CheckRunUpdate{Status: "completed", Conclusion: "skipped"})
+		}
+		client.inputs.successConclusions = c.successConclusions
+
+		result := dispatchWithClient(context.Background(), client)
+		if result.Succeeded != c.succeeded {
+			t.Errorf("expected a skipped check to succeed=%v with success conclusions %v, got error: %v", c.succeeded, c.successConclusions, result.err)
+		}
+		if result.Conclusion != "skipped" {
+			t.Errorf("expected the conclusion of the check to be reported, got %q", result.Conclusion)
+		}
+	}
+}
+
+func TestDispatchInvalidOutputs(t *testing.T) {
+	server, client := newTestClient(t)
+	server.OnDispatch = func(dispatch fakegithub.Dispatch) {
+		server.ScriptCheckRun(dispatchedCheckId(t, dispatch), fakegithub.CheckRunUpdate{
+			Status:     "completed",
+			Conclusion: "success",
+			Text:       "---- BEGIN CHECK OUTPUT v1 name=a ----\n{}",
+		})
+	}
+
+	result := dispatchWithClient(context.Background(), client)
+	if result.Succeeded {
+		t.Fatal("expected the dispatch to fail")
+	}
+	if class := errorClassOf(result.err); class != errorClassFailure {
+		t.Errorf("expected a failure error, got %v: %v", class, result.err)
+	}
+	if !strings.Contains(result.Error, "Invalid outputs in check report") || strings.Contains(result.Error, "fetching") {
+		t.Errorf("expected the error to describe the invalid outputs, got %v", result.Error)
+	}
+}
+
+func TestDispatchOutputSchema(t *testing.T) {
+	schema, err := compileOutputSchema("output_schema.json", []byte(testOutputSchema))
+	if err != nil {
+		t.Fatalf("unexpected error compiling the schema: %v", err)
+	}
+
+	cases := []struct {
+		output    string
+		succeeded bool
+	}{
+		{`{"version": "1.2.3"}`, true},
+		{`{"version": 3}`, false},
+	}
+
+	for _, c := range cases {
+		server, client := newTestClient(t)
+		server.OnDispatch = func(dispatch fakegithub.Dispatch) {
+			server.ScriptCheckRun(dispatchedCheckId(t, dispatch), fakegithub.CheckRunUpdate{
+				Status:     "completed",
+				Conclusion: "success",
+				Text:       "```json " + outputsStartIndicator + "\n" + c.output + "\n```",
+			})
+		}
+		client.inputs.outputSchema = &outputSchema{schema: schema, annotationPath: ".github/workflows/dispatch.yml"}
+
+		result := dispatchWithClient(context.Background(), client)
+		if result.Succeeded != c.succeeded {
+			t.Fatalf("expected output %v to succeed=%v, got error: %v", c.output, c.succeeded, result.err)
+		}
+		if c.succeeded {
+			continue
+		}
+
+		if result.Conclusion != "failure" || result.Output != nil {
+			t.Errorf("expected the check to fail without outputs, got %+v", result)
+		}
+		check := server.CheckRun(result.CheckId)
+		annotations := check.GetOutput().Annotations
+		if len(annotations) != 1 || annotations[0].GetMessage() != "/version: expected string, but got number" || annotations[0].GetPath() != ".github/workflows/dispatch.yml" {
+			t.Errorf("expected the check to be annotated with the violation, got %v", annotations)
+		}
+		if !strings.Contains(check.GetOutput().GetText(), c.output) {
+			t.Errorf("expected the text of the check to be kept, got %q", check.GetOutput().GetText())
+		}
+	}
+}
+
+func TestDispatchErrorClasses(t *testing.T) {
+	cases := []struct {
+		name  string
+		setup func(server *fakegithub.Server, client *GitHubClient)
+		class errorClass
+		// conclusion is the expected conclusion of the check, if the
+		// dispatch failed after creating it
+		conclusion string
+	}{
+		{
+			name: "missing repository",
+			setup: func(server *fakegithub.Server, client *GitHubClient) {
+				client.inputs.targetRepository = "missing"
+			},
+			class: errorClassNotFound,
+		},
+		{
+			name: "unknown input",
+			setup: func(server *fakegithub.Server, client *GitHubClient) {
+				client.inputs.workflowInputs["unknown"] = "value"
+			},
+			class: errorClassValidation,
+		},
+		{
+			name: "rate limited",
+			setup: func(server *fakegithub.Server, client *GitHubClient) {
+				server.SetRateLimit(0, time.Now().Add(time.Hour))
+			},
+			class: errorClassRateLimit,
+		},
+		{
+			name: "dispatch server error",
+			setup: func(server *fakegithub.Server, client *GitHubClient) {
+				server.FailRequests("POST", "/repos/target-owner/target/actions/workflows/", 502, 3)
+			},
+			class:      errorClassTransient,
+			conclusion: "failure",
+		},
+		{
+			name: "dispatch rejected",
+			setup: func(server *fakegithub.Server, client *GitHubClient) {
+				server.FailRequests("POST", "/repos/target-owner/target/actions/workflows/", 422, 1)
+			},
+			class:      errorClassValidation,
+			conclusion: "action_required",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server, client := newTestClient(t)
+			c.setup(server, client)
+
+			result := dispatchWithClient(context.Background(), client)
+			if result.Succeeded {
+				t.Fatal("expected the dispatch to fail")
+			}
+			if class := errorClassOf(result.err); class != c.class {
+				t.Errorf("expected a %v error, got %v: %v", c.class, class, result.err)
+			}
+			if c.conclusion == "" {
+				if result.CheckId != 0 {
+					t.Errorf("expected no check to be created")
+				}
+				return
+			}
+			check := server.CheckRun(result.CheckId)
+			if check.GetStatus() != "completed" || check.GetConclusion() != c.conclusion {
+				t.Errorf("expected the check to conclude %v after the dispatch failed, got %v %v", c.conclusion, check.GetStatus(), check.GetConclusion())
+			}
+			if !strings.Contains(check.GetOutput().GetSummary(), result.Error) {
+				t.Errorf("expected the summary of the check to explain the failure, got %q", check.GetOutput().GetSummary())
+			}
+		})
+	}
+}
+
+func TestDispatchMatrixValidatesEveryEntry(t *testing.T) {
+	server, client := newTestClient(t)
+	completeOnDispatch(t, server)
+	server.AddWorkflow("target-owner", "target", ".github/workflows/deploy.yml", testWorkflow+`      region:
+        type: choice
+        options: [us-east-1, eu-west-1]
+`, 42)
+
+	client.inputs.maxParallel = 2
+	dispatchClients := func(matrix ...map[string]interface{}) []*GitHubClient {
+		client.inputs.matrix = matrix
+		clients := []*GitHubClient{}
+		for _, dispatchInputs := range expandDispatches(client.inputs, client.inputs.targets) {
+			dispatchClient := *client
+			dispatchClient.inputs = dispatchInputs
+			clients = append(clients, &dispatchClient)
+		}
+		return clients
+	}
+
+	_, err := dispatchWithClients(context.Background(), dispatchClients(
+		map[string]interface{}{"region": "us-east-1"},
+		map[string]interface{}{"region": "ap-south-1"},
+	))
+	if errorClassOf(err) != errorClassValidation || !strings.Contains(err.Error(), "1 of 2 dispatches are invalid") || !strings.Contains(err.Error(), "region=ap-south-1") {
+		t.Fatalf("expected a validation error for the invalid entry, got %v", err)
+	}
+	for _, request := range server.Requests() {
+		if strings.HasPrefix(request, "POST") {
+			t.Errorf("expected nothing to be created or dispatched, got %v", request)
+		}
+	}
+	if dispatches := len(server.Dispatches()); dispatches != 0 {
+		t.Errorf("expected no dispatches, got %d", dispatches)
+	}
+
+	results, err := dispatchWithClients(context.Background(), dispatchClients(
+		map[string]interface{}{"region": "us-east-1"},
+		map[string]interface{}{"region": "eu-west-1"},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if !result.Succeeded {
+			t.Errorf("expected %v to succeed, got error: %v", describeMatrixEntry(result.Matrix), result.err)
+		}
+	}
+}
+
+func TestDispatchAllSharesClientsPerRepository(t *testing.T) {
+	server, client := newTestClient(t)
+	completeOnDispatch(t, server)
+	server.AddInstallation(1, "source-owner")
+	server.AddInstallation(2, "target-owner", "target")
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	githubVars := client.githubVars
+	githubVars.apiUrl = server.URL
+	client.inputs.credentials = appCredentials{appID: 123, privateKey: privateKey, installationId: -1}
+	client.inputs.baseTransport = http.DefaultTransport
+	client.inputs.maxParallel = 2
+	client.inputs.matrix = []map[string]interface{}{{"environment": "staging"}, {"environment": "qa"}, {"environment": "production"}}
+
+	results, err := dispatchAll(context.Background(), githubVars, expandDispatches(client.inputs, client.inputs.targets))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, result := range results {
+		if !result.Succeeded {
+			t.Errorf("expected %v to succeed, got error: %v", describeMatrixEntry(result.Matrix), result.err)
+		}
+	}
+
+	// The api url of the server is treated as that of an enterprise server,
+	// so requests other than those of the apps transport are under /api/v3
+	requests := map[string]int{}
+	for _, request := range server.Requests() {
+		requests[strings.Replace(request, " /api/v3/", " /", 1)]++
+	}
+	for _, request := range []string{
+		"GET /repos/target-owner/target/installation",
+		"POST /app/installations/2/access_tokens",
+		"POST /app/installations/1/access_tokens",
+	} {
+		if requests[request] != 1 {
+			t.Errorf("expected %v once for every dispatch, got %d", request, requests[request])
+		}
+	}
+}
+
+func TestFindInstallation(t *testing.T) {
+	server := fakegithub.New()
+	defer server.Close()
+
+	server.AddInstallation(1, "some-org", "other")
+	server.AddInstallation(2, "some-org", "target")
+	apps := server.Client().Apps
+
+	cases := []struct {
+		installationId int64
+		repository     string
+		expected       int64
+	}{
+		{-1, "target", 2},
+		{-1, "", 1},
+		{2, "other", 2},
+	}
+
+	for _, c := range cases {
+		id, err := findInstallation(context.Background(), apps, appCredentials{appID: 123, installationId: c.installationId}, "some-org", c.repository)
+		if err != nil {
+			t.Fatalf("unexpected error finding installation for %q: %v", c.repository, err)
+		}
+		if id != c.expected {
+			t.Errorf("expected installation %d for %q, got %d", c.expected, c.repository, id)
+		}
+	}
+}
+
+// dispatchedCheckId returns the ID of the check a dispatch was made for.
+// It is called from the server, so failures are reported with t.Errorf
+func dispatchedCheckId(t *testing.T, dispatch fakegithub.Dispatch) int64 {
+	checkId, err := strconv.ParseInt(fmt.Sprint(dispatch.Inputs["check_id"]), 10, 64)
+	if err != nil {
+		t.Errorf("dispatch did not include a valid check_id: %v", dispatch.Inputs)
+	}
+	return checkId
+}
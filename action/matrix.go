@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxMatrixEntries mirrors the limit GitHub places on the number of jobs
+// a single workflow matrix may generate
+const maxMatrixEntries = 256
+
+// parseWorkflowInputs parses the workflow_inputs input. It may be a JSON
+// object of inputs for a single dispatch, a JSON array of such objects for
+// one dispatch per element, or a JSON object with a "matrix" key mapping
+// input names to arrays of values, which expands into one dispatch per
+// combination of values. Other keys alongside "matrix" are given to every
+// dispatch. Returns the inputs common to every dispatch and, when more than
+// one dispatch is requested, the inputs specific to each.
+func parseWorkflowInputs(workflowInputsString string) (map[string]interface{}, []map[string]interface{}, error) {
+	if strings.HasPrefix(strings.TrimSpace(workflowInputsString), "[") {
+		entries := []map[string]interface{}{}
+		err := json.Unmarshal([]byte(workflowInputsString), &entries)
+		if err != nil {
+			return nil, nil, fmt.Errorf("input 'workflow_inputs' is not a json array of objects: %w", err)
+		}
+		if len(entries) == 0 {
+			return nil, nil, errors.New("input 'workflow_inputs' must not be an empty array")
+		}
+		if len(entries) > maxMatrixEntries {
+			return nil, nil, fmt.Errorf("input 'workflow_inputs' has %d entries, more than the maximum of %d", len(entries), maxMatrixEntries)
+		}
+		return map[string]interface{}{}, entries, nil
+	}
+
+	workflowInputs := map[string]interface{}{}
+	err := json.Unmarshal([]byte(workflowInputsString), &workflowInputs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("input 'workflow_inputs' is not json: %w", err)
+	}
+
+	rawMatrix, ok := workflowInputs["matrix"].(map[string]interface{})
+	if !ok {
+		return workflowInputs, nil, nil
+	}
+	delete(workflowInputs, "matrix")
+
+	entries, err := expandMatrix(rawMatrix)
+	if err != nil {
+		return nil, nil, fmt.Errorf("input 'workflow_inputs' matrix is invalid: %w", err)
+	}
+
+	return workflowInputs, entries, nil
+}
+
+// expandMatrix computes every combination of the values in the matrix.
+// Input names are expanded in alphabetical order so that the entries are
+// produced in a stable order
+func expandMatrix(matrix map[string]interface{}) ([]map[string]interface{}, error) {
+	names := make([]string, 0, len(matrix))
+	for name := range matrix {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	entries := []map[string]interface{}{{}}
+	for _, name := range names {
+		values, ok := matrix[name].([]interface{})
+		if !ok || len(values) == 0 {
+			return nil, fmt.Errorf("'%v' must be a non-empty array of values", name)
+		}
+
+		expanded := make([]map[string]interface{}, 0, len(entries)*len(values))
+		for _, entry := range entries {
+			for _, value := range values {
+				combination := map[string]interface{}{}
+				for k, v := range entry {
+					combination[k] = v
+				}
+				combination[name] = value
+				expanded = append(expanded, combination)
+			}
+		}
+		entries = expanded
+
+		if len(entries) > maxMatrixEntries {
+			return nil, fmt.Errorf("expands to more than the maximum of %d entries", maxMatrixEntries)
+		}
+	}
+
+	return entries, nil
+}
+
+// isMatrix reports whether the inputs request more than one dispatch to
+// each target repository
+func (inputs inputs) isMatrix() bool {
+	return inputs.matrix != nil
+}
+
+// forMatrixEntry returns a copy of the inputs for a single entry of the
+// matrix, with the inputs of the entry merged into the workflow inputs
+func (inputs inputs) forMatrixEntry(entry map[string]interface{}) inputs {
+	scoped := inputs
+	scoped.matrix = nil
+	scoped.matrixEntry = entry
+	scoped.workflowInputs = map[string]interface{}{}
+	for key, value := range inputs.workflowInputs {
+		scoped.workflowInputs[key] = value
+	}
+	for key, value := range entry {
+		scoped.workflowInputs[key] = value
+	}
+	scoped.checkName = fmt.Sprintf("%v (%v)", inputs.checkName, describeMatrixEntry(entry))
+	return scoped
+}
+
+// describeMatrixEntry formats the inputs of a matrix entry for display,
+// e.g. "environment=production, region=us-east-1"
+func describeMatrixEntry(entry map[string]interface{}) string {
+	names := make([]string, 0, len(entry))
+	for name := range entry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%v=%v", name, entry[name]))
+	}
+	return strings.Join(pairs, ", ")
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseWorkflowInputsObject(t *testing.T) {
+
+	workflowInputs, matrix, err := parseWorkflowInputs(`{ "environment": "production" }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matrix != nil {
+		t.Errorf("expected no matrix, got %v", matrix)
+	}
+	if workflowInputs["environment"] != "production" {
+		t.Errorf("unexpected inputs %v", workflowInputs)
+	}
+}
+
+func TestParseWorkflowInputsArray(t *testing.T) {
+
+	_, matrix, err := parseWorkflowInputs(`[{ "environment": "staging" }, { "environment": "production" }]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matrix) != 2 || matrix[1]["environment"] != "production" {
+		t.Errorf("unexpected matrix %v", matrix)
+	}
+}
+
+func TestParseWorkflowInputsMatrix(t *testing.T) {
+
+	workflowInputs, matrix, err := parseWorkflowInputs(`{
+		"version": "1.2.3",
+		"matrix": {
+			"region": ["us-east-1", "eu-west-1"],
+			"environment": ["staging", "production"]
+		}
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := workflowInputs["matrix"]; ok || workflowInputs["version"] != "1.2.3" {
+		t.Errorf("unexpected common inputs %v", workflowInputs)
+	}
+
+	expected := []string{
+		"environment=staging, region=us-east-1",
+		"environment=staging, region=eu-west-1",
+		"environment=production, region=us-east-1",
+		"environment=production, region=eu-west-1",
+	}
+	if len(matrix) != len(expected) {
+		t.Fatalf("expected %d entries, got %d", len(expected), len(matrix))
+	}
+	for i, entry := range matrix {
+		if describeMatrixEntry(entry) != expected[i] {
+			t.Errorf("expected %v, got %v", expected[i], describeMatrixEntry(entry))
+		}
+	}
+}
+
+func TestParseWorkflowInputsInvalid(t *testing.T) {
+
+	for _, workflowInputs := range []string{`[]`, `[1, 2]`, `{ "matrix": { "region": [] } }`, `{ "matrix": { "region": "us-east-1" } }`, `not json`} {
+		if _, _, err := parseWorkflowInputs(workflowInputs); err == nil {
+			t.Errorf("expected an error parsing '%v'", workflowInputs)
+		}
+	}
+}
@@ -1,11 +1,31 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
 	"strings"
+
+	"github.com/sethvargo/go-githubactions"
 )
 
 const outputsStartIndicator = "---- BEGIN CHECK OUTPUT ----"
 
+// defaultOutputPrefix is prepended to the names of the keys of a JSON
+// output promoted to outputs of their own
+const defaultOutputPrefix = "output_"
+
+// builtinOutputs are the outputs the action sets itself, which a promoted
+// key must not replace, see promoteOutputs
+var builtinOutputs = []string{
+	"output", "results", "check_id", "check_url", "status", "conclusion", "started_at", "completed_at", "duration_seconds",
+	"run_id", "run_url", "target_run_id", "target_run_url", "artifacts", "artifact_paths",
+}
+
 // parseOutputsFromText takes a string and parses out any "outputs"
 // included therein. The outputs are delimited using a code block (```)
 // annotated with the outputsStartIndicator
@@ -22,3 +42,111 @@ func parseOutputsFromText(reportText *string) string {
 	outputs := strings.Split(splitReport[1], "```")[0]
 	return strings.TrimSpace(outputs)
 }
+
+// outputNamePattern matches the names a JSON output key may be promoted to
+// an output of the action under
+var outputNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// setOutputs sets each of the outputs of the action, in order of name
+func setOutputs(outputs map[string]string) {
+	names := make([]string, 0, len(outputs))
+	for name := range outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		setOutput(name, outputs[name])
+	}
+}
+
+// setOutput sets an output of the action by appending it to the file named
+// by GITHUB_OUTPUT, delimited so that values may span several lines. The
+// deprecated set-output command is used where the file is not available
+func setOutput(name, value string) {
+	outputFile := os.Getenv("GITHUB_OUTPUT")
+	if outputFile == "" {
+		githubactions.SetOutput(name, value)
+		return
+	}
+
+	err := appendOutput(outputFile, name, value)
+	if err != nil {
+		githubactions.Warningf("Error writing output %v to GITHUB_OUTPUT, falling back to the set-output command: %v", name, err.Error())
+		githubactions.SetOutput(name, value)
+	}
+}
+
+// appendOutput appends an output to a GITHUB_OUTPUT file using the
+// name<<delimiter syntax, with a random delimiter which the value does not
+// contain
+func appendOutput(outputFile, name, value string) error {
+	delimiter := ""
+	for delimiter == "" || strings.Contains(value, delimiter) {
+		random := make([]byte, 16)
+		_, err := rand.Read(random)
+		if err != nil {
+			return err
+		}
+		delimiter = "ghadelimiter_" + hex.EncodeToString(random)
+	}
+
+	f, err := os.OpenFile(outputFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(f, "%v<<%v\n%v\n%v\n", name, delimiter, value, delimiter)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// promoteOutputs returns each top-level key of a JSON object output as an
+// output named with the given prefix. String values are set as they are and
+// other values as JSON. Keys which cannot be the name of an output, or
+// would replace one of the builtinOutputs, are skipped
+func promoteOutputs(output json.RawMessage, prefix string) map[string]string {
+	outputs := map[string]string{}
+	if len(output) == 0 {
+		return outputs
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(output, &fields); err != nil {
+		// Not an object, so there are no keys to promote
+		return outputs
+	}
+
+	for key, rawValue := range fields {
+		name := prefix + key
+		if !outputNamePattern.MatchString(name) {
+			githubactions.Warningf("Key %q of the output is not a valid output name, so it is only set as part of 'output'", key)
+			continue
+		}
+		if isBuiltinOutput(name) {
+			githubactions.Warningf("Key %q of the output would replace the %q output of the action, so it is only set as part of 'output'", key, name)
+			continue
+		}
+
+		var value string
+		if err := json.Unmarshal(rawValue, &value); err != nil {
+			value = string(rawValue)
+		}
+		outputs[name] = value
+	}
+	return outputs
+}
+
+// isBuiltinOutput reports whether an output name is one of the
+// builtinOutputs. Output names are case-insensitive
+func isBuiltinOutput(name string) bool {
+	for _, builtin := range builtinOutputs {
+		if strings.EqualFold(name, builtin) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -37,3 +41,66 @@ func TestParseOutputEmpty(t *testing.T) {
 	}
 
 }
+
+func TestAppendOutput(t *testing.T) {
+	outputFile := filepath.Join(t.TempDir(), "output")
+	values := map[string]string{
+		"single":    "value",
+		"multiline": "first\nsecond\n",
+		"delimiter": "ghadelimiter_\nEOF",
+	}
+	for _, name := range []string{"single", "multiline", "delimiter"} {
+		if err := appendOutput(outputFile, name, values[name]); err != nil {
+			t.Fatalf("unexpected error appending output %v: %v", name, err)
+		}
+	}
+
+	contents, err := os.ReadFile(outputFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Read the outputs back the way the runner does
+	parsed := map[string]string{}
+	lines := strings.Split(string(contents), "\n")
+	for i := 0; i < len(lines) && lines[i] != ""; i++ {
+		nameDelimiter := strings.SplitN(lines[i], "<<", 2)
+		if len(nameDelimiter) != 2 {
+			t.Fatalf("unexpected line in output file: %q", lines[i])
+		}
+		value := []string{}
+		for i++; lines[i] != nameDelimiter[1]; i++ {
+			value = append(value, lines[i])
+		}
+		parsed[nameDelimiter[0]] = strings.Join(value, "\n")
+	}
+
+	if !reflect.DeepEqual(parsed, values) {
+		t.Errorf("expected outputs %q, got %q", values, parsed)
+	}
+}
+
+func TestPromoteOutputs(t *testing.T) {
+	output := json.RawMessage(`{"version": "1.2.3", "replicas": 3, "tags": ["a", "b"], "not valid": true}`)
+
+	expected := map[string]string{
+		"output_version":  "1.2.3",
+		"output_replicas": "3",
+		"output_tags":     `["a", "b"]`,
+	}
+	if promoted := promoteOutputs(output, defaultOutputPrefix); !reflect.DeepEqual(promoted, expected) {
+		t.Errorf("expected %v, got %v", expected, promoted)
+	}
+
+	if promoted := promoteOutputs(json.RawMessage(`["not", "an", "object"]`), defaultOutputPrefix); len(promoted) != 0 {
+		t.Errorf("expected nothing to be promoted from an array, got %v", promoted)
+	}
+
+	// Without a prefix, keys named like the outputs of the action itself
+	// must not replace them
+	output = json.RawMessage(`{"version": "1.2.3", "conclusion": "success", "RUN_URL": "https://example.com", "output": "x"}`)
+	expected = map[string]string{"version": "1.2.3"}
+	if promoted := promoteOutputs(output, ""); !reflect.DeepEqual(promoted, expected) {
+		t.Errorf("expected %v, got %v", expected, promoted)
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// outputProtocolVersion is the version of the output protocol parsed by
+	// parseOutputBlocks
+	outputProtocolVersion = "v1"
+	// maxOutputBlocks bounds the number of output blocks in a check report
+	maxOutputBlocks = 50
+
+	outputFormatJSON   = "json"
+	outputFormatYAML   = "yaml"
+	outputFormatDotenv = "dotenv"
+)
+
+var (
+	// outputBlockBeginPattern matches the line opening an output block, e.g.
+	// ---- BEGIN CHECK OUTPUT v1 name=deploy format=yaml ----
+	outputBlockBeginPattern = regexp.MustCompile(`^---- BEGIN CHECK OUTPUT (v\d+)((?: [a-z]+=[^ ]*)*) ----$`)
+	// outputBlockEndPattern matches the line closing an output block, e.g.
+	// ---- END CHECK OUTPUT deploy ----
+	outputBlockEndPattern = regexp.MustCompile(`^---- END CHECK OUTPUT ([^ ]+) ----$`)
+	// dotenvKeyPattern matches the keys of dotenv formatted blocks
+	dotenvKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+)
+
+// outputBlock is a named block of outputs in a check report
+type outputBlock struct {
+	name     string
+	format   string
+	encoding string
+	// line is the line of the report the block begins on
+	line    int
+	payload []string
+}
+
+// parseCheckOutputs parses the outputs of a check report. Reports using
+// the output protocol yield a JSON object with the value of each block by
+// name, see parseOutputBlocks. Otherwise the single legacy block is
+// returned as it is, see parseOutputsFromText, provided it is valid JSON
+func parseCheckOutputs(reportText *string) (string, error) {
+	if reportText == nil {
+		return "", nil
+	}
+
+	blocks, err := parseOutputBlocks(*reportText)
+	if err != nil {
+		return "", fmt.Errorf("Invalid outputs in check report: %w", err)
+	}
+	if len(blocks) == 0 {
+		legacyOutputs := parseOutputsFromText(reportText)
+		if legacyOutputs != "" {
+			if err := json.Compact(&bytes.Buffer{}, []byte(legacyOutputs)); err != nil {
+				return "", fmt.Errorf("Invalid outputs in check report: legacy outputs block: invalid JSON: %w", err)
+			}
+		}
+		return legacyOutputs, nil
+	}
+
+	outputs := map[string]json.RawMessage{}
+	for _, block := range blocks {
+		value, err := block.decode()
+		if err != nil {
+			return "", fmt.Errorf("Invalid outputs in check report: block %q on line %d: %w", block.name, block.line, err)
+		}
+		outputs[block.name] = value
+	}
+
+	rawOutputs, err := json.Marshal(outputs)
+	if err != nil {
+		return "", fmt.Errorf("Error marshaling outputs: %w", err)
+	}
+	return string(rawOutputs), nil
+}
+
+// parseOutputBlocks finds the output blocks of a report. Each block begins
+// with a line declaring the version of the protocol, the name of the block
+// and optionally its format (json by default) and encoding (base64):
+//
+//	---- BEGIN CHECK OUTPUT v1 name=deploy format=yaml encoding=base64 ----
+//
+// and ends with a line naming the block again:
+//
+//	---- END CHECK OUTPUT deploy ----
+//
+// Every line in between is the payload of the block (without the code fence
+// wrapping it, if any), so blocks may contain code fences and may
+// themselves be wrapped in them. Markers must be on lines of their own, and
+// blocks may not be nested or left unclosed
+func parseOutputBlocks(reportText string) ([]*outputBlock, error) {
+	blocks := []*outputBlock{}
+	names := map[string]int{}
+	var current *outputBlock
+
+	scanner := bufio.NewScanner(strings.NewReader(reportText))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(reportText)+1)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		marker := strings.TrimSpace(line)
+
+		if strings.HasPrefix(marker, "---- BEGIN CHECK OUTPUT v") {
+			if current != nil {
+				return nil, fmt.Errorf("line %d: block %q on line %d is not closed before another block begins", lineNumber, current.name, current.line)
+			}
+			block, err := parseOutputBlockBegin(marker, lineNumber)
+			if err != nil {
+				return nil, err
+			}
+			if previous, ok := names[block.name]; ok {
+				return nil, fmt.Errorf("line %d: block %q was already given on line %d", lineNumber, block.name, previous)
+			}
+			if len(blocks) == maxOutputBlocks {
+				return nil, fmt.Errorf("line %d: more than %d blocks", lineNumber, maxOutputBlocks)
+			}
+			names[block.name] = lineNumber
+			current = block
+			continue
+		}
+
+		if match := outputBlockEndPattern.FindStringSubmatch(marker); match != nil {
+			if current == nil {
+				return nil, fmt.Errorf("line %d: block %q ends without beginning", lineNumber, match[1])
+			}
+			if match[1] != current.name {
+				return nil, fmt.Errorf("line %d: block %q ends while block %q on line %d is open", lineNumber, match[1], current.name, current.line)
+			}
+			blocks = append(blocks, current)
+			current = nil
+			continue
+		}
+
+		if current != nil {
+			current.payload = append(current.payload, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if current != nil {
+		return nil, fmt.Errorf("block %q on line %d is not closed with '---- END CHECK OUTPUT %v ----'", current.name, current.line, current.name)
+	}
+
+	return blocks, nil
+}
+
+// parseOutputBlockBegin parses the line opening an output block
+func parseOutputBlockBegin(marker string, lineNumber int) (*outputBlock, error) {
+	match := outputBlockBeginPattern.FindStringSubmatch(marker)
+	if match == nil {
+		return nil, fmt.Errorf("line %d: malformed output block marker %q, expected '---- BEGIN CHECK OUTPUT %v name=<name> [format=json|yaml|dotenv] [encoding=base64] ----'", lineNumber, marker, outputProtocolVersion)
+	}
+	if match[1] != outputProtocolVersion {
+		return nil, fmt.Errorf("line %d: unsupported output protocol version %v, this action supports %v", lineNumber, match[1], outputProtocolVersion)
+	}
+
+	block := &outputBlock{format: outputFormatJSON, line: lineNumber}
+	for _, attribute := range strings.Fields(match[2]) {
+		keyValue := strings.SplitN(attribute, "=", 2)
+		switch keyValue[0] {
+		case "name":
+			block.name = keyValue[1]
+		case "format":
+			block.format = keyValue[1]
+		case "encoding":
+			block.encoding = keyValue[1]
+		default:
+			return nil, fmt.Errorf("line %d: unknown output block attribute %q", lineNumber, keyValue[0])
+		}
+	}
+
+	if !outputNamePattern.MatchString(block.name) {
+		return nil, fmt.Errorf("line %d: output block name %q must start with a letter or underscore and contain only letters, digits, '_' and '-'", lineNumber, block.name)
+	}
+	if block.format != outputFormatJSON && block.format != outputFormatYAML && block.format != outputFormatDotenv {
+		return nil, fmt.Errorf("line %d: output block %q has unknown format %q, expected json, yaml or dotenv", lineNumber, block.name, block.format)
+	}
+	if block.encoding != "" && block.encoding != "base64" {
+		return nil, fmt.Errorf("line %d: output block %q has unknown encoding %q, expected base64", lineNumber, block.name, block.encoding)
+	}
+
+	return block, nil
+}
+
+// decode decodes the payload of the block according to its encoding and
+// format, returning its value as JSON
+func (block *outputBlock) decode() (json.RawMessage, error) {
+	payload := []byte(strings.Join(unfence(block.payload), "\n"))
+	if block.encoding == "base64" {
+		decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(payload)), ""))
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64: %w", err)
+		}
+		payload = decoded
+	}
+
+	var value interface{}
+	switch block.format {
+	case outputFormatJSON:
+		compacted := &bytes.Buffer{}
+		if err := json.Compact(compacted, payload); err != nil {
+			return nil, fmt.Errorf("invalid JSON: %w", err)
+		}
+		return compacted.Bytes(), nil
+	case outputFormatYAML:
+		if err := yaml.Unmarshal(payload, &value); err != nil {
+			return nil, fmt.Errorf("invalid YAML: %w", err)
+		}
+	case outputFormatDotenv:
+		values, err := parseDotenv(string(payload))
+		if err != nil {
+			return nil, fmt.Errorf("invalid dotenv: %w", err)
+		}
+		value = values
+	}
+
+	rawValue, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("value cannot be represented as JSON: %w", err)
+	}
+	return rawValue, nil
+}
+
+// unfence removes the code fence wrapping the lines of a payload, if any, so
+// that payloads may be rendered as code in the check report
+func unfence(lines []string) []string {
+	first, last := 0, len(lines)-1
+	for first <= last && strings.TrimSpace(lines[first]) == "" {
+		first++
+	}
+	for last >= first && strings.TrimSpace(lines[last]) == "" {
+		last--
+	}
+	if last > first && strings.HasPrefix(strings.TrimSpace(lines[first]), "```") && strings.TrimSpace(lines[last]) == "```" {
+		return lines[first+1 : last]
+	}
+	return lines
+}
+
+// parseDotenv parses KEY=value lines. Blank lines and lines starting with #
+// are ignored, keys may be preceded by "export", and values may be quoted:
+// within double quotes escapes such as \n and \" are interpreted, while
+// single quoted values are taken literally
+func parseDotenv(payload string) (map[string]string, error) {
+	values := map[string]string{}
+	for i, line := range strings.Split(payload, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		keyValue := strings.SplitN(strings.TrimPrefix(line, "export "), "=", 2)
+		key := strings.TrimSpace(keyValue[0])
+		if len(keyValue) != 2 || !dotenvKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("line %d of the block is not formatted as KEY=value", i+1)
+		}
+		value := strings.TrimSpace(keyValue[1])
+
+		switch {
+		case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d of the block has an invalid quoted value", i+1)
+			}
+			value = unquoted
+		case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+			value = value[1 : len(value)-1]
+		case strings.HasPrefix(value, `"`) || strings.HasPrefix(value, "'"):
+			return nil, fmt.Errorf("line %d of the block has an unterminated quoted value", i+1)
+		}
+
+		values[key] = value
+	}
+	return values, nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCheckOutputs(t *testing.T) {
+	reportText := strings.Join([]string{
+		"# Deployed",
+		"```",
+		"---- BEGIN CHECK OUTPUT v1 name=deploy ----",
+		`{"version": "1.2.3",`,
+		`  "notes": "contains a fence:\n` + "```" + `"}`,
+		"---- END CHECK OUTPUT deploy ----",
+		"```",
+		"Text mentioning ---- BEGIN CHECK OUTPUT v1 name=ignored ---- is not a marker",
+		"---- BEGIN CHECK OUTPUT v1 name=config format=yaml ----",
+		"```yaml",
+		"replicas: 3",
+		"regions: [us-east-1, eu-west-1]",
+		"```",
+		"---- END CHECK OUTPUT config ----",
+		"---- BEGIN CHECK OUTPUT v1 name=env format=dotenv ----\r",
+		"# comment",
+		"export IMAGE=app:1.2.3",
+		`MESSAGE="two\nlines"`,
+		"LITERAL='$not \\n expanded'",
+		"---- END CHECK OUTPUT env ----\r",
+		"---- BEGIN CHECK OUTPUT v1 name=encoded encoding=base64 ----",
+		base64.StdEncoding.EncodeToString([]byte(`{"secret": "---- END CHECK OUTPUT encoded ----"}`)),
+		"---- END CHECK OUTPUT encoded ----",
+	}, "\n")
+
+	rawOutputs, err := parseCheckOutputs(&reportText)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var outputs map[string]interface{}
+	if err := json.Unmarshal([]byte(rawOutputs), &outputs); err != nil {
+		t.Fatalf("expected a JSON object, got %q", rawOutputs)
+	}
+	expected := map[string]interface{}{
+		"deploy":  map[string]interface{}{"version": "1.2.3", "notes": "contains a fence:\n```"},
+		"config":  map[string]interface{}{"replicas": float64(3), "regions": []interface{}{"us-east-1", "eu-west-1"}},
+		"env":     map[string]interface{}{"IMAGE": "app:1.2.3", "MESSAGE": "two\nlines", "LITERAL": "$not \\n expanded"},
+		"encoded": map[string]interface{}{"secret": "---- END CHECK OUTPUT encoded ----"},
+	}
+	if !reflect.DeepEqual(outputs, expected) {
+		t.Errorf("expected %v, got %v", expected, outputs)
+	}
+}
+
+func TestParseCheckOutputsLegacy(t *testing.T) {
+	reportText := "```json " + outputsStartIndicator + "\n{\"my_output\": \"my_value\"}\n```"
+
+	outputs, err := parseCheckOutputs(&reportText)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if outputs != `{"my_output": "my_value"}` {
+		t.Errorf("expected the legacy block to be returned as it is, got %q", outputs)
+	}
+}
+
+func TestParseCheckOutputsInvalid(t *testing.T) {
+	block := func(marker string, lines ...string) string {
+		return strings.Join(append([]string{marker}, lines...), "\n")
+	}
+	tooMany := []string{}
+	for i := 0; i <= maxOutputBlocks; i++ {
+		tooMany = append(tooMany, block(fmt.Sprintf("---- BEGIN CHECK OUTPUT v1 name=b%d ----", i), "{}", fmt.Sprintf("---- END CHECK OUTPUT b%d ----", i)))
+	}
+
+	cases := []struct {
+		name       string
+		reportText string
+		expected   string
+	}{
+		{"unclosed", block("---- BEGIN CHECK OUTPUT v1 name=a ----", "{}"), `block "a" on line 1 is not closed`},
+		{"nested", block("---- BEGIN CHECK OUTPUT v1 name=a ----", "---- BEGIN CHECK OUTPUT v1 name=b ----", "{}", "---- END CHECK OUTPUT b ----", "---- END CHECK OUTPUT a ----"), "line 2: block \"a\" on line 1 is not closed before another block begins"},
+		{"mismatched end", block("---- BEGIN CHECK OUTPUT v1 name=a ----", "{}", "---- END CHECK OUTPUT b ----"), `block "b" ends while block "a"`},
+		{"end without begin", block("---- END CHECK OUTPUT a ----"), `block "a" ends without beginning`},
+		{"duplicate", block("---- BEGIN CHECK OUTPUT v1 name=a ----", "{}", "---- END CHECK OUTPUT a ----", "---- BEGIN CHECK OUTPUT v1 name=a ----", "{}", "---- END CHECK OUTPUT a ----"), `block "a" was already given on line 1`},
+		{"too many", strings.Join(tooMany, "\n"), fmt.Sprintf("more than %d blocks", maxOutputBlocks)},
+		{"unsupported version", block("---- BEGIN CHECK OUTPUT v2 name=a ----", "{}", "---- END CHECK OUTPUT a ----"), "unsupported output protocol version v2"},
+		{"malformed marker", block("---- BEGIN CHECK OUTPUT v1 name=a", "{}", "---- END CHECK OUTPUT a ----"), "malformed output block marker"},
+		{"missing name", block("---- BEGIN CHECK OUTPUT v1 format=json ----", "{}"), `output block name ""`},
+		{"unknown attribute", block("---- BEGIN CHECK OUTPUT v1 name=a compression=gzip ----", "{}", "---- END CHECK OUTPUT a ----"), `unknown output block attribute "compression"`},
+		{"unknown format", block("---- BEGIN CHECK OUTPUT v1 name=a format=xml ----", "<a/>", "---- END CHECK OUTPUT a ----"), `unknown format "xml"`},
+		{"invalid JSON", block("---- BEGIN CHECK OUTPUT v1 name=a ----", `{"a": }`, "---- END CHECK OUTPUT a ----"), `block "a" on line 1: invalid JSON`},
+		{"trailing JSON", block("---- BEGIN CHECK OUTPUT v1 name=a ----", `{} {}`, "---- END CHECK OUTPUT a ----"), "invalid JSON"},
+		{"empty JSON", block("---- BEGIN CHECK OUTPUT v1 name=a ----", "---- END CHECK OUTPUT a ----"), "invalid JSON"},
+		{"invalid base64", block("---- BEGIN CHECK OUTPUT v1 name=a encoding=base64 ----", "not base64!", "---- END CHECK OUTPUT a ----"), "invalid base64"},
+		{"invalid YAML", block("---- BEGIN CHECK OUTPUT v1 name=a format=yaml ----", "a: [", "---- END CHECK OUTPUT a ----"), "invalid YAML"},
+		{"YAML not representable as JSON", block("---- BEGIN CHECK OUTPUT v1 name=a format=yaml ----", "a: .inf", "---- END CHECK OUTPUT a ----"), "cannot be represented as JSON"},
+		{"invalid dotenv", block("---- BEGIN CHECK OUTPUT v1 name=a format=dotenv ----", "A=1", "not a variable", "---- END CHECK OUTPUT a ----"), "line 2 of the block is not formatted as KEY=value"},
+		{"invalid legacy JSON", "```json " + outputsStartIndicator + "\n{\"a\": }\n```", "legacy outputs block: invalid JSON"},
+		{"unterminated dotenv quote", block("---- BEGIN CHECK OUTPUT v1 name=a format=dotenv ----", `A="open`, "---- END CHECK OUTPUT a ----"), "unterminated quoted value"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := parseCheckOutputs(&c.reportText)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), c.expected) {
+				t.Errorf("expected an error containing %q, got %v", c.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/v37/github"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// maxOutputSchemaAnnotations bounds the violations annotated on the
+	// check, which is the most the GitHub api accepts in a single request
+	maxOutputSchemaAnnotations = 50
+	// maxOutputSchemaViolationsReported bounds the violations described in
+	// the error failing the dispatch
+	maxOutputSchemaViolationsReported = 5
+)
+
+// outputSchema is a JSON Schema the outputs of a dispatch are validated
+// against, see validate
+type outputSchema struct {
+	schema *jsonschema.Schema
+	// annotationPath is the file in the repository using this action which
+	// violations are annotated on: the schema itself when given as a path,
+	// otherwise the workflow using the action
+	annotationPath string
+}
+
+// outputSchemaViolation describes a part of the outputs which does not
+// match the schema
+type outputSchemaViolation struct {
+	// location is a JSON pointer to the invalid value within the outputs
+	location string
+	message  string
+}
+
+func (violation outputSchemaViolation) String() string {
+	location := violation.location
+	if location == "" {
+		location = "/"
+	}
+	return fmt.Sprintf("%v: %v", location, violation.message)
+}
+
+// parseOutputSchema parses the 'output_schema' input, which is either a
+// schema given inline as a JSON object or the path of a JSON (or, with a
+// .yml or .yaml extension, YAML) schema in the workspace of the job. No
+// schema is returned if the input is not set
+func parseOutputSchema() (*outputSchema, error) {
+	input := strings.TrimSpace(os.Getenv("INPUT_OUTPUT_SCHEMA"))
+	if input == "" {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(input, "{") {
+		schema, err := compileOutputSchema("output_schema.json", []byte(input))
+		if err != nil {
+			return nil, err
+		}
+		return &outputSchema{schema: schema, annotationPath: callingWorkflowPath()}, nil
+	}
+
+	schemaPath := filepath.Join(os.Getenv("GITHUB_WORKSPACE"), input)
+	content, err := os.ReadFile(schemaPath)
+	if err != nil {
+		return nil, fmt.Errorf("input 'output_schema' is not a JSON object, nor a readable file: %w", err)
+	}
+	if extension := path.Ext(input); extension == ".yml" || extension == ".yaml" {
+		content, err = yamlToJSON(content)
+		if err != nil {
+			return nil, fmt.Errorf("input 'output_schema' %v is not valid YAML: %w", input, err)
+		}
+	}
+
+	absolutePath, err := filepath.Abs(schemaPath)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := compileOutputSchema(absolutePath, content)
+	if err != nil {
+		return nil, err
+	}
+	return &outputSchema{schema: schema, annotationPath: path.Clean(input)}, nil
+}
+
+// compileOutputSchema compiles the schema at the given url with the given
+// content. References to other schemas are resolved relative to the url
+func compileOutputSchema(url string, content []byte) (*jsonschema.Schema, error) {
+	compiler := jsonschema.NewCompiler()
+	err := compiler.AddResource(url, bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("input 'output_schema' is not valid JSON: %w", err)
+	}
+	schema, err := compiler.Compile(url)
+	if err != nil {
+		return nil, fmt.Errorf("input 'output_schema' is not a valid JSON Schema: %w", err)
+	}
+	return schema, nil
+}
+
+// yamlToJSON converts a YAML document to JSON
+func yamlToJSON(content []byte) ([]byte, error) {
+	var value interface{}
+	err := yaml.Unmarshal(content, &value)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+// callingWorkflowPath returns the path of the workflow using this action,
+// from a GITHUB_WORKFLOW_REF of the form
+// owner/repository/.github/workflows/workflow.yml@refs/heads/main
+func callingWorkflowPath() string {
+	workflowRef := os.Getenv("GITHUB_WORKFLOW_REF")
+	workflowPath := strings.SplitN(strings.SplitN(workflowRef, "@", 2)[0], "/", 3)
+	if len(workflowPath) != 3 {
+		return ".github/workflows"
+	}
+	return workflowPath[2]
+}
+
+// validate validates the raw output scraped from a check report against the
+// schema, returning the violations found (ordered by location) if it does not match
+func (schema *outputSchema) validate(rawOutput string) []outputSchemaViolation {
+	if strings.TrimSpace(rawOutput) == "" {
+		return []outputSchemaViolation{{message: "the check report has no outputs"}}
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(rawOutput))
+	decoder.UseNumber()
+	var output interface{}
+	if err := decoder.Decode(&output); err != nil {
+		return []outputSchemaViolation{{message: fmt.Sprintf("the output is not valid JSON: %v", err)}}
+	}
+
+	err := schema.schema.Validate(output)
+	if err == nil {
+		return nil
+	}
+	validationErr, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return []outputSchemaViolation{{message: err.Error()}}
+	}
+
+	// Only the errors without causes describe what is wrong with the output,
+	// the others describe which parts of the schema failed to match
+	violations := []outputSchemaViolation{}
+	var collect func(*jsonschema.ValidationError)
+	collect = func(validationErr *jsonschema.ValidationError) {
+		if len(validationErr.Causes) == 0 {
+			violations = append(violations, outputSchemaViolation{location: validationErr.InstanceLocation, message: validationErr.Message})
+		}
+		for _, cause := range validationErr.Causes {
+			collect(cause)
+		}
+	}
+	collect(validationErr)
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].location < violations[j].location
+	})
+	return violations
+}
+
+// outputSchemaError returns the error failing a dispatch whose outputs
+// have the given violations of the schema
+func outputSchemaError(violations []outputSchemaViolation) error {
+	described := []string{}
+	for i, violation := range violations {
+		if i == maxOutputSchemaViolationsReported {
+			described = append(described, fmt.Sprintf("and %d more", len(violations)-i))
+			break
+		}
+		described = append(described, violation.String())
+	}
+	return newError(errorClassFailure, "The output does not match output_schema: %v", strings.Join(described, "; "))
+}
+
+// annotations describes the violations of the schema as annotations on the
+// check, up to the most the GitHub api accepts at once
+func (schema *outputSchema) annotations(violations []outputSchemaViolation) []*github.CheckRunAnnotation {
+	annotations := []*github.CheckRunAnnotation{}
+	for i, violation := range violations {
+		if i == maxOutputSchemaAnnotations {
+			break
+		}
+		annotations = append(annotations, &github.CheckRunAnnotation{
+			Path:            github.String(schema.annotationPath),
+			StartLine:       github.Int(1),
+			EndLine:         github.Int(1),
+			AnnotationLevel: github.String("failure"),
+			Title:           github.String("Output does not match output_schema"),
+			Message:         github.String(violation.String()),
+		})
+	}
+	return annotations
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testOutputSchema = `{
+	"type": "object",
+	"required": ["version"],
+	"properties": {
+		"version": {"type": "string", "pattern": "^[0-9.]+$"},
+		"replicas": {"type": "integer", "minimum": 1}
+	}
+}`
+
+func TestOutputSchemaValidate(t *testing.T) {
+	schema, err := compileOutputSchema("output_schema.json", []byte(testOutputSchema))
+	if err != nil {
+		t.Fatalf("unexpected error compiling the schema: %v", err)
+	}
+	validator := &outputSchema{schema: schema}
+
+	cases := []struct {
+		name      string
+		rawOutput string
+		expected  []string
+	}{
+		{"valid", `{"version": "1.2.3", "replicas": 3}`, nil},
+		{"missing property", `{"replicas": 3}`, []string{"/: missing properties: 'version'"}},
+		{"invalid properties", `{"version": "latest", "replicas": 0.5}`, []string{
+			"/replicas: expected integer, but got number",
+			"/version: does not match pattern '^[0-9.]+$'",
+		}},
+		{"no outputs", "", []string{"/: the check report has no outputs"}},
+		{"not JSON", "version=1.2.3", []string{"/: the output is not valid JSON: invalid character 'v' looking for beginning of value"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var violations []string
+			for _, violation := range validator.validate(c.rawOutput) {
+				violations = append(violations, violation.String())
+			}
+			if !reflect.DeepEqual(violations, c.expected) {
+				t.Errorf("expected violations %q, got %q", c.expected, violations)
+			}
+		})
+	}
+}
+
+func TestParseOutputSchema(t *testing.T) {
+	workspace := t.TempDir()
+	os.Setenv("GITHUB_WORKSPACE", workspace)
+	defer os.Unsetenv("GITHUB_WORKSPACE")
+	defer os.Unsetenv("INPUT_OUTPUT_SCHEMA")
+
+	err := os.MkdirAll(filepath.Join(workspace, "schemas"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(workspace, "schemas", "deploy.yml"), []byte("type: object\nrequired: [version]\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("INPUT_OUTPUT_SCHEMA", "schemas/deploy.yml")
+	schema, err := parseOutputSchema()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if schema.annotationPath != "schemas/deploy.yml" {
+		t.Errorf("expected violations to be annotated on the schema, got %q", schema.annotationPath)
+	}
+	if violations := schema.validate(`{}`); len(violations) != 1 {
+		t.Errorf("expected the YAML schema to require a version, got %v", violations)
+	}
+
+	os.Setenv("INPUT_OUTPUT_SCHEMA", testOutputSchema)
+	schema, err = parseOutputSchema()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if violations := schema.validate(`{"version": "1.2.3"}`); len(violations) != 0 {
+		t.Errorf("expected the inline schema to accept the output, got %v", violations)
+	}
+
+	for _, invalid := range []string{"schemas/missing.json", `{"type": "object"`, `{"type": "unknown"}`} {
+		os.Setenv("INPUT_OUTPUT_SCHEMA", invalid)
+		if _, err := parseOutputSchema(); err == nil {
+			t.Errorf("expected an error for output_schema %q", invalid)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v37/github"
+	"github.com/sethvargo/go-githubactions"
+)
+
+const (
+	// logTailLines is the number of lines of the log of a failed job shown
+	logTailLines = 50
+	// maxLogTailAttempts bounds the attempts to fetch the log of a failed
+	// job, which may only become available some time after it completes
+	maxLogTailAttempts = 3
+	// logDownloadTimeout bounds the download of the log of a failed job,
+	// which may be far larger than its tail
+	logDownloadTimeout = time.Minute
+)
+
+// runProgress follows the jobs and steps of the dispatched workflow run,
+// logging them as they start and complete, see follow
+type runProgress struct {
+	// repository is the target repository, which prefixes the logs of its
+	// jobs
+	repository string
+	// jobs holds the state of each job as last logged
+	jobs map[int64]*github.WorkflowJob
+	// logTailAttempts counts the attempts to fetch the log of each failed
+	// job, which is -1 once it has been logged
+	logTailAttempts map[int64]int
+}
+
+func newRunProgress(repository string) *runProgress {
+	return &runProgress{
+		repository:      repository,
+		jobs:            map[int64]*github.WorkflowJob{},
+		logTailAttempts: map[int64]int{},
+	}
+}
+
+// follow fetches the jobs of the run and logs the jobs and steps which
+// started or completed since they were last fetched, along with the tail of
+// the log of any job which failed. Each job is logged in a group of its own.
+// Progress is informational only, so errors are logged as warnings
+func (progress *runProgress) follow(ctx context.Context, client *GitHubClient, runId int64) {
+	jobs, err := client.ListWorkflowJobs(ctx, runId)
+	if err != nil {
+		githubactions.Warningf("Error following the jobs of workflow run %v: %v", runId, err.Error())
+		return
+	}
+
+	for _, job := range jobs {
+		previous := progress.jobs[job.GetID()]
+		progress.jobs[job.GetID()] = job
+
+		lines := describeJobTransitions(previous, job)
+		if len(lines) > 0 {
+			githubactions.Infof("%v", formatGroup(fmt.Sprintf("%v: job %v %v", progress.repository, escapeCommandText(job.GetName()), describeJobState(job)), lines))
+		}
+
+		if job.GetConclusion() == "failure" {
+			progress.logFailure(ctx, client, job)
+		}
+	}
+}
+
+// logFailure logs the tail of the log of a failed job, unless it has
+// already been logged or could not be fetched too many times
+func (progress *runProgress) logFailure(ctx context.Context, client *GitHubClient, job *github.WorkflowJob) {
+	attempts := progress.logTailAttempts[job.GetID()]
+	if attempts < 0 || attempts >= maxLogTailAttempts {
+		return
+	}
+	progress.logTailAttempts[job.GetID()] = attempts + 1
+
+	tail, err := client.FetchJobLogTail(ctx, job.GetID(), logTailLines)
+	if err != nil {
+		githubactions.Warningf("Error fetching the log of job %v: %v", job.GetName(), err.Error())
+		return
+	}
+	progress.logTailAttempts[job.GetID()] = -1
+
+	title := fmt.Sprintf("%v: log of failed job %v (last %d lines)", progress.repository, escapeCommandText(job.GetName()), logTailLines)
+	githubactions.Infof("%v", formatGroup(title, withoutCommands(tail)))
+}
+
+// describeJobTransitions describes the changes to a job and its steps since
+// it was last fetched, as lines of the log. The previous state of the job is
+// nil when it is fetched for the first time
+func describeJobTransitions(previous, job *github.WorkflowJob) []string {
+	previousSteps := map[int64]*github.TaskStep{}
+	if previous != nil {
+		for _, step := range previous.Steps {
+			previousSteps[step.GetNumber()] = step
+		}
+	}
+
+	lines := []string{}
+	for _, step := range job.Steps {
+		previousStep := previousSteps[step.GetNumber()]
+		if previousStep != nil && previousStep.GetStatus() == step.GetStatus() {
+			continue
+		}
+
+		switch step.GetStatus() {
+		case "in_progress":
+			lines = append(lines, fmt.Sprintf("  Started %v", escapeCommandText(step.GetName())))
+		case "completed":
+			lines = append(lines, fmt.Sprintf("  %v %v%v", describeStepConclusion(step.GetConclusion()), escapeCommandText(step.GetName()), describeDuration(step.StartedAt, step.CompletedAt)))
+		}
+	}
+
+	if len(lines) == 0 && (previous == nil || previous.GetStatus() != job.GetStatus()) && job.GetStatus() != "queued" {
+		lines = append(lines, fmt.Sprintf("  Job %v", describeJobState(job)))
+	}
+	return lines
+}
+
+// describeJobState describes the status of a job, along with its conclusion
+// and duration once completed
+func describeJobState(job *github.WorkflowJob) string {
+	if job.GetStatus() != "completed" {
+		return job.GetStatus()
+	}
+	return fmt.Sprintf("completed (%v)%v", job.GetConclusion(), describeDuration(job.StartedAt, job.CompletedAt))
+}
+
+// describeStepConclusion marks the conclusion of a step in the log
+func describeStepConclusion(conclusion string) string {
+	switch conclusion {
+	case "success":
+		return "✓"
+	case "skipped":
+		return "-"
+	default:
+		return fmt.Sprintf("✗ (%v)", conclusion)
+	}
+}
+
+// describeDuration describes the time between two timestamps, if both are
+// known
+func describeDuration(startedAt, completedAt *github.Timestamp) string {
+	if startedAt == nil || completedAt == nil {
+		return ""
+	}
+	return fmt.Sprintf(" in %v", completedAt.Sub(startedAt.Time).Round(time.Second))
+}
+
+// formatGroup formats lines as a group in the log, so they can be collapsed.
+// The group is formatted as a whole so it can be logged at once, without
+// being interleaved with the logs of concurrent dispatches
+func formatGroup(title string, lines []string) string {
+	return fmt.Sprintf("::group::%v\n%v\n::endgroup::\n", title, strings.Join(lines, "\n"))
+}
+
+// escapeCommandText escapes the line breaks of a name from another workflow
+// as workflow commands escape their data, so that the name cannot begin a
+// line of the log with a workflow command of its own
+func escapeCommandText(text string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(text)
+}
+
+// withoutCommands wraps lines from the log of another workflow so that any
+// workflow commands in them (e.g. setting outputs) are not run by this one
+func withoutCommands(lines []string) []string {
+	token, err := newExternalId()
+	if err != nil {
+		token = fmt.Sprint(time.Now().UnixNano())
+	}
+	wrapped := append([]string{fmt.Sprintf("::stop-commands::%v", token)}, lines...)
+	return append(wrapped, fmt.Sprintf("::%v::", token))
+}
+
+// ListWorkflowJobs lists the jobs of the latest attempt of a workflow run in
+// the target repository
+func (client *GitHubClient) ListWorkflowJobs(ctx context.Context, runId int64) ([]*github.WorkflowJob, error) {
+	jobs := []*github.WorkflowJob{}
+	opts := &github.ListWorkflowJobsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		var page *github.Jobs
+		var response *github.Response
+		err := client.attempt(ctx, func(ctx context.Context) error {
+			var err error
+			page, response, err = client.api.Actions.ListWorkflowJobs(ctx, client.inputs.targetOwner, client.inputs.targetRepository, runId, opts)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, page.Jobs...)
+		if response.NextPage == 0 {
+			return jobs, nil
+		}
+		opts.Page = response.NextPage
+	}
+}
+
+// FetchJobLogTail returns the last lines of the log of a job in the target
+// repository, downloaded from the url GitHub redirects to, see openDownload
+func (client *GitHubClient) FetchJobLogTail(ctx context.Context, jobId int64, lines int) ([]string, error) {
+	var logUrl string
+	err := client.attempt(ctx, func(ctx context.Context) error {
+		location, _, err := client.api.Actions.GetWorkflowJobLogs(ctx, client.inputs.targetOwner, client.inputs.targetRepository, jobId, false)
+		if err == nil {
+			logUrl = location.String()
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, logDownloadTimeout)
+	defer cancel()
+	log, err := client.openDownload(ctx, logUrl)
+	if err != nil {
+		return nil, fmt.Errorf("downloading the log failed: %w", err)
+	}
+	defer log.Close()
+
+	return tailLines(log, lines)
+}
+
+// tailLines reads the last lines of a log, without the timestamp GitHub
+// prefixes each line with
+func tailLines(log io.Reader, lines int) ([]string, error) {
+	tail := make([]string, 0, lines)
+	scanner := bufio.NewScanner(log)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if timestamp := strings.SplitN(line, " ", 2); len(timestamp) == 2 {
+			if _, err := time.Parse(time.RFC3339Nano, strings.TrimPrefix(timestamp[0], "\ufeff")); err == nil {
+				line = timestamp[1]
+			}
+		}
+		if len(tail) == lines {
+			tail = tail[1:]
+		}
+		tail = append(tail, line)
+	}
+	return tail, scanner.Err()
+}
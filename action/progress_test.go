@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DrizlyInc/workflow-dispatch-action/action/m/v2/internal/fakegithub"
+	"github.com/google/go-github/v37/github"
+)
+
+func TestDescribeJobTransitions(t *testing.T) {
+	startedAt := &github.Timestamp{Time: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)}
+	completedAt := &github.Timestamp{Time: startedAt.Add(time.Second * 12)}
+	step := func(number int64, status, conclusion string) *github.TaskStep {
+		step := &github.TaskStep{Number: github.Int64(number), Name: github.String(fmt.Sprintf("step %d", number)), Status: github.String(status), StartedAt: startedAt}
+		if status == "completed" {
+			step.Conclusion = github.String(conclusion)
+			step.CompletedAt = completedAt
+		}
+		return step
+	}
+	job := func(status string, steps ...*github.TaskStep) *github.WorkflowJob {
+		return &github.WorkflowJob{Name: github.String("deploy"), Status: github.String(status), Steps: steps}
+	}
+
+	cases := []struct {
+		name     string
+		previous *github.WorkflowJob
+		job      *github.WorkflowJob
+		expected []string
+	}{
+		{"queued", nil, job("queued"), []string{}},
+		{"started without steps", job("queued"), job("in_progress"), []string{"  Job in_progress"}},
+		{"steps progress", job("in_progress", step(1, "completed", "success"), step(2, "in_progress", "")), job("in_progress", step(1, "completed", "success"), step(2, "completed", "failure"), step(3, "in_progress", ""), step(4, "queued", "")), []string{
+			"  ✗ (failure) step 2 in 12s",
+			"  Started step 3",
+		}},
+		{"first seen", nil, job("in_progress", step(1, "completed", "skipped")), []string{"  - step 1 in 12s"}},
+		{"unchanged", job("in_progress", step(1, "in_progress", "")), job("in_progress", step(1, "in_progress", "")), []string{}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if lines := describeJobTransitions(c.previous, c.job); !reflect.DeepEqual(lines, c.expected) {
+				t.Errorf("expected %q, got %q", c.expected, lines)
+			}
+		})
+	}
+}
+
+func TestDescribeJobTransitionsEscapesNames(t *testing.T) {
+	job := &github.WorkflowJob{Name: github.String("deploy"), Status: github.String("in_progress"), Steps: []*github.TaskStep{
+		{Number: github.Int64(1), Name: github.String("Deploy\n::set-output name=version::injected"), Status: github.String("in_progress")},
+	}}
+
+	expected := []string{"  Started Deploy%0A::set-output name=version::injected"}
+	if lines := describeJobTransitions(nil, job); !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected the step name not to begin a line, got %q", lines)
+	}
+	if title := escapeCommandText("100% done\r\n::error::x"); title != "100%25 done%0D%0A::error::x" {
+		t.Errorf("unexpected escaped text %q", title)
+	}
+}
+
+func TestTailLines(t *testing.T) {
+	log := "\ufeff2022-01-01T00:00:00.0000000Z first\r\n2022-01-01T00:00:01.0000000Z second\nthird without timestamp\n2022-01-01T00:00:02.0000000Z ::set-output name=x::y\n"
+
+	tail, err := tailLines(strings.NewReader(log), 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"second", "third without timestamp", "::set-output name=x::y"}
+	if !reflect.DeepEqual(tail, expected) {
+		t.Errorf("expected %q, got %q", expected, tail)
+	}
+
+	wrapped := withoutCommands(tail)
+	token := strings.TrimPrefix(wrapped[0], "::stop-commands::")
+	if token == wrapped[0] || token == "" || wrapped[len(wrapped)-1] != "::"+token+"::" {
+		t.Errorf("expected the lines to be wrapped in stop-commands, got %q", wrapped)
+	}
+}
+
+func TestFollowRunProgress(t *testing.T) {
+	server, client := newTestClient(t)
+	client.inputs.streamProgress = true
+
+	failedJob := &github.WorkflowJob{
+		ID:         github.Int64(7),
+		Name:       github.String("deploy"),
+		Status:     github.String("completed"),
+		Conclusion: github.String("failure"),
+		Steps: []*github.TaskStep{
+			{Number: github.Int64(1), Name: github.String("Deploy"), Status: github.String("completed"), Conclusion: github.String("failure")},
+		},
+	}
+	server.SetJobLog(7, "2022-01-01T00:00:00.0000000Z Error: deployment failed\n")
+	server.OnDispatch = func(dispatch fakegithub.Dispatch) {
+		server.ScriptWorkflowRun(dispatch.RunID,
+			fakegithub.WorkflowRunUpdate{Status: "in_progress", Jobs: []*github.WorkflowJob{
+				{ID: github.Int64(7), Name: github.String("deploy"), Status: github.String("in_progress")},
+			}},
+			fakegithub.WorkflowRunUpdate{Status: "in_progress", Jobs: []*github.WorkflowJob{failedJob}},
+			fakegithub.WorkflowRunUpdate{Status: "in_progress"},
+		)
+		server.ScriptCheckRun(dispatchedCheckId(t, dispatch),
+			fakegithub.CheckRunUpdate{Status: "in_progress"},
+			fakegithub.CheckRunUpdate{Status: "in_progress"},
+			fakegithub.CheckRunUpdate{Status: "in_progress"},
+			fakegithub.CheckRunUpdate{Status: "completed", Conclusion: "failure"},
+		)
+	}
+
+	result := dispatchWithClient(context.Background(), client)
+	if result.Succeeded {
+		t.Fatal("expected the dispatch to fail")
+	}
+
+	jobListings, logDownloads := 0, 0
+	for _, request := range server.Requests() {
+		if strings.HasSuffix(request, fmt.Sprintf("/actions/runs/%d/jobs", result.RunId)) {
+			jobListings++
+		}
+		if request == "GET /_logs/7" {
+			logDownloads++
+		}
+	}
+	if jobListings < 3 {
+		t.Errorf("expected the jobs of the run to be listed every poll, got %d listings", jobListings)
+	}
+	if logDownloads != 1 {
+		t.Errorf("expected the log of the failed job to be downloaded once, got %d downloads", logDownloads)
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sethvargo/go-githubactions"
+)
+
+const (
+	// secondaryRateLimitPause is how long to pause after hitting a secondary
+	// rate limit without a Retry-After header, as recommended by GitHub
+	secondaryRateLimitPause = time.Minute
+	// maxRateLimitRetries bounds how many times a rate limited request is
+	// retried after pausing
+	maxRateLimitRetries = 3
+	// requestsPerPoll is the number of requests made by each poll of a check
+	// when the workflow run is also polled
+	requestsPerPoll = 2
+)
+
+// rateBudgetThresholds are the fractions of the rate limit at which the
+// remaining budget is logged as it is used up
+var rateBudgetThresholds = []float64{0.5, 0.25, 0.1, 0.05}
+
+// rateBudget tracks the primary rate limit of the GitHub api for a single
+// installation (or token) from the headers of its responses, along with any
+// pause required by a secondary rate limit. A budget is shared by every
+// client authenticating as the same installation, see budgetFor
+type rateBudget struct {
+	name string
+
+	mu          sync.Mutex
+	known       bool
+	limit       int
+	remaining   int
+	reset       time.Time
+	pausedUntil time.Time
+	// logged is the index of the next threshold to log the budget at
+	logged int
+}
+
+var rateBudgets = struct {
+	sync.Mutex
+	budgets map[string]*rateBudget
+}{budgets: map[string]*rateBudget{}}
+
+// budgetFor returns the budget of the installation (or token) with the
+// given name, creating it if needed
+func budgetFor(name string) *rateBudget {
+	rateBudgets.Lock()
+	defer rateBudgets.Unlock()
+
+	budget, ok := rateBudgets.budgets[name]
+	if !ok {
+		budget = &rateBudget{name: name}
+		rateBudgets.budgets[name] = budget
+	}
+	return budget
+}
+
+// update records the rate limit reported by the headers of a response
+func (budget *rateBudget) update(header http.Header) {
+	resource := header.Get("X-RateLimit-Resource")
+	if resource != "" && resource != "core" {
+		return
+	}
+
+	limit, limitErr := strconv.Atoi(header.Get("X-RateLimit-Limit"))
+	remaining, remainingErr := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	reset, resetErr := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+	if limitErr != nil || remainingErr != nil || resetErr != nil {
+		return
+	}
+
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+
+	resetTime := time.Unix(reset, 0)
+	if budget.known && resetTime.After(budget.reset) {
+		// The limit was reset since the last response
+		budget.logged = 0
+	}
+	budget.known = true
+	budget.limit = limit
+	budget.remaining = remaining
+	budget.reset = resetTime
+
+	githubactions.Debugf("GitHub api rate limit for %v: %d of %d remaining, resets at %v", budget.name, remaining, limit, resetTime.Format(time.RFC3339))
+	crossedThreshold := false
+	for budget.logged < len(rateBudgetThresholds) && float64(remaining) <= rateBudgetThresholds[budget.logged]*float64(limit) {
+		budget.logged++
+		crossedThreshold = true
+	}
+	if crossedThreshold {
+		githubactions.Infof("GitHub api rate limit for %v: %d of %d requests remaining until %v\n", budget.name, remaining, limit, resetTime.Format(time.RFC3339))
+	}
+}
+
+// pause stops requests for the budget until the given time
+func (budget *rateBudget) pause(until time.Time) {
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+
+	if until.After(budget.pausedUntil) {
+		budget.pausedUntil = until
+	}
+}
+
+// waitTime returns how long requests must wait before being sent, either
+// due to a secondary rate limit or because the primary limit is exhausted
+func (budget *rateBudget) waitTime() time.Duration {
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+
+	now := time.Now()
+	wait := budget.pausedUntil.Sub(now)
+	if budget.known && budget.remaining == 0 && budget.reset.Sub(now) > wait {
+		wait = budget.reset.Sub(now) + time.Second
+	}
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// covers reports whether the budget can cover the given number of requests
+// made before the given time. A budget which is unknown, or which resets
+// before then, is assumed to cover them
+func (budget *rateBudget) covers(requests int, before time.Time) (bool, int, time.Time) {
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+
+	if !budget.known || budget.reset.Before(before) {
+		return true, budget.remaining, budget.reset
+	}
+	return budget.remaining >= requests, budget.remaining, budget.reset
+}
+
+// rateLimitPausedError is returned instead of sending a request while its
+// budget is paused or exhausted, when the request cannot wait for it to
+// resume before its deadline
+type rateLimitPausedError struct {
+	name  string
+	until time.Time
+}
+
+func (err *rateLimitPausedError) Error() string {
+	return fmt.Sprintf("Requests to the GitHub api for %v are paused by its rate limit until %v", err.name, err.until.Format(time.RFC3339))
+}
+
+// rateLimitTransport sends requests on behalf of a rateBudget: requests
+// wait while the budget is paused or exhausted, and requests rejected by a
+// primary or secondary rate limit pause the budget and are retried once it
+// resumes. A request whose deadline would not outlast the wait is not sent,
+// failing with a rateLimitPausedError instead, and a rejected request which
+// cannot wait to be retried returns the rate limit error to the caller
+type rateLimitTransport struct {
+	base   http.RoundTripper
+	budget *rateBudget
+}
+
+func newRateLimitTransport(budget *rateBudget, base http.RoundTripper) *rateLimitTransport {
+	return &rateLimitTransport{base: base, budget: budget}
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := t.wait(req); err != nil {
+			return nil, err
+		}
+
+		resp, err := t.base.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		t.budget.update(resp.Header)
+
+		pauseUntil, limited := rateLimitedUntil(resp)
+		if !limited {
+			return resp, nil
+		}
+		t.budget.pause(pauseUntil)
+		githubactions.Warningf("GitHub api rate limit for %v exceeded, pausing requests until %v", t.budget.name, pauseUntil.Format(time.RFC3339))
+
+		// The rejected request was not processed, so it is safe to retry
+		// (if its body can be sent again) once the budget resumes
+		if attempt == maxRateLimitRetries || !t.canWait(req) || (req.Body != nil && req.GetBody == nil) {
+			return resp, nil
+		}
+		resp.Body.Close()
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// wait waits until the budget allows the request to be sent, or fails
+// without waiting if the request cannot wait that long
+func (t *rateLimitTransport) wait(req *http.Request) error {
+	wait := t.budget.waitTime()
+	if wait == 0 {
+		return nil
+	}
+	if !t.canWait(req) {
+		return &rateLimitPausedError{name: t.budget.name, until: time.Now().Add(wait)}
+	}
+
+	githubactions.Infof("Waiting %v for the GitHub api rate limit for %v to reset\n", wait.Round(time.Second), t.budget.name)
+	return sleepContext(req.Context(), wait)
+}
+
+// canWait reports whether the request can wait for the budget to resume
+// before its deadline
+func (t *rateLimitTransport) canWait(req *http.Request) bool {
+	deadline, ok := req.Context().Deadline()
+	return !ok || time.Now().Add(t.budget.waitTime()).Before(deadline)
+}
+
+// rateLimitedUntil reports whether a response rejected the request due to a
+// primary or secondary rate limit, and until when requests should pause
+func rateLimitedUntil(resp *http.Response) (time.Time, bool) {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return time.Time{}, false
+	}
+
+	if retryAfter, ok := retryAfterHeader(resp.Header); ok {
+		return time.Now().Add(retryAfter), true
+	}
+
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			return time.Unix(reset, 0).Add(time.Second), true
+		}
+	}
+
+	// Secondary rate limits are only identified by the message of the
+	// response, so the body is read and replaced
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return time.Time{}, false
+	}
+	message := strings.ToLower(string(body))
+	if strings.Contains(message, "secondary rate limit") || strings.Contains(message, "abuse") {
+		return time.Now().Add(secondaryRateLimitPause), true
+	}
+
+	return time.Time{}, false
+}
+
+// estimatePollRequests estimates the number of requests made by polling a
+// check for the given duration under a backoff policy, ignoring jitter and
+// any changes of state which would reset the interval
+func estimatePollRequests(policy backoffPolicy, duration time.Duration) int {
+	if policy.initial <= 0 {
+		return 0
+	}
+
+	b := backoff{policy: backoffPolicy{initial: policy.initial, max: policy.max, multiplier: policy.multiplier}, current: policy.initial}
+	polls := 1
+	for elapsed := b.next(); elapsed < duration; elapsed += b.next() {
+		polls++
+	}
+	return polls * requestsPerPoll
+}
+
+// checkWaitBudget returns a rate limit error if the remaining rate limit of
+// the client cannot cover polling for a check for the given duration, so a
+// long wait which would fail part way through is not started
+func checkWaitBudget(client *GitHubClient, duration time.Duration) error {
+	if _, polling := client.inputs.checkWaiter().(pollingWaiter); !polling {
+		return nil
+	}
+
+	for _, api := range []*githubApi{client.sourceApi, client.api} {
+		if api == nil || api.budget == nil {
+			continue
+		}
+		requests := estimatePollRequests(client.inputs.pollBackoff, duration)
+		if client.inputs.streamProgress && api == client.api {
+			// Following the progress of the run lists its jobs every poll
+			requests += requests / requestsPerPoll
+		}
+		if ok, remaining, reset := api.budget.covers(requests, time.Now().Add(duration)); !ok {
+			return newError(errorClassRateLimit, "The GitHub api rate limit for %v has %d requests remaining until %v, not enough to wait up to %v for the check (about %d requests). Increase poll_interval_seconds or use wait_strategy webhook", api.budget.name, remaining, reset.Format(time.RFC3339), duration, requests)
+		}
+		githubactions.Debugf("GitHub api rate limit for %v covers waiting up to %v for the check (about %d requests)", api.budget.name, duration, requests)
+	}
+
+	return nil
+}
+
+// logRateBudgets logs the remaining budget of every installation (or token)
+// used by the action
+func logRateBudgets() {
+	rateBudgets.Lock()
+	defer rateBudgets.Unlock()
+
+	for _, budget := range rateBudgets.budgets {
+		githubactions.Infof("GitHub api rate limit for %v\n", budget.describe())
+	}
+}
+
+// describe summarizes the remaining budget for logs
+func (budget *rateBudget) describe() string {
+	budget.mu.Lock()
+	defer budget.mu.Unlock()
+
+	if !budget.known {
+		return fmt.Sprintf("%v: unknown", budget.name)
+	}
+	return fmt.Sprintf("%v: %d of %d requests remaining until %v", budget.name, budget.remaining, budget.limit, budget.reset.Format(time.RFC3339))
+}
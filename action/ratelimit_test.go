@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func rateLimitHeader(limit, remaining int, reset time.Time) http.Header {
+	return http.Header{
+		"X-Ratelimit-Limit":     []string{fmt.Sprint(limit)},
+		"X-Ratelimit-Remaining": []string{fmt.Sprint(remaining)},
+		"X-Ratelimit-Reset":     []string{fmt.Sprint(reset.Unix())},
+	}
+}
+
+func TestRateBudgetCovers(t *testing.T) {
+	budget := &rateBudget{name: "test"}
+	if ok, _, _ := budget.covers(1000, time.Now().Add(time.Hour)); !ok {
+		t.Error("expected an unknown budget to be assumed to cover any requests")
+	}
+
+	reset := time.Now().Add(time.Minute * 30)
+	budget.update(rateLimitHeader(5000, 100, reset))
+	if ok, _, _ := budget.covers(50, time.Now().Add(time.Hour)); !ok {
+		t.Error("expected 100 remaining requests to cover 50")
+	}
+	if ok, _, _ := budget.covers(200, time.Now().Add(time.Minute*10)); ok {
+		t.Error("expected 100 remaining requests not to cover 200 before the reset")
+	}
+	if ok, _, _ := budget.covers(200, time.Now().Add(time.Hour)); !ok {
+		t.Error("expected a budget which resets during the wait to cover it")
+	}
+
+	searchHeader := rateLimitHeader(30, 0, reset)
+	searchHeader.Set("X-RateLimit-Resource", "search")
+	budget.update(searchHeader)
+	if budget.waitTime() != 0 {
+		t.Error("expected the rate limits of other resources to be ignored")
+	}
+}
+
+func TestRateLimitTransportRetriesSecondaryLimit(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"message": "You have exceeded a secondary rate limit"}`)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: newRateLimitTransport(&rateBudget{name: "test"}, http.DefaultTransport)}
+	resp, err := client.Post(server.URL, "application/json", strings.NewReader(`{"ref": "main"}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.StatusCode != http.StatusCreated || requests != 2 {
+		t.Errorf("expected the request to be retried once, got status %d after %d requests", resp.StatusCode, requests)
+	}
+}
+
+func TestRateLimitTransportHonorsPause(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"message": "You have exceeded a secondary rate limit"}`)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: newRateLimitTransport(&rateBudget{name: "test"}, http.DefaultTransport)}
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+		resp, err := client.Do(req)
+		cancel()
+		if i == 0 {
+			if err != nil || resp.StatusCode != http.StatusForbidden {
+				t.Fatalf("expected the rate limit error to be returned, got %v (%v)", resp, err)
+			}
+			resp.Body.Close()
+			continue
+		}
+		if errorClassOf(err) != errorClassRateLimit {
+			t.Errorf("expected a rate limit error while paused, got %v", err)
+		}
+		if delay, ok := retryDelay(err); !ok || delay < time.Second*55 {
+			t.Errorf("expected to be told to wait for the pause, got %v", delay)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("expected no requests to be sent while paused, got %d", requests)
+	}
+}
+
+func TestRateLimitedUntil(t *testing.T) {
+	reset := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	primary := &http.Response{StatusCode: http.StatusForbidden, Header: rateLimitHeader(5000, 0, reset)}
+	if until, ok := rateLimitedUntil(primary); !ok || until.Before(reset) {
+		t.Errorf("expected a primary rate limit until %v, got %v (%v)", reset, until, ok)
+	}
+
+	forbidden := httptest.NewRecorder()
+	forbidden.WriteHeader(http.StatusForbidden)
+	fmt.Fprint(forbidden, `{"message": "Resource not accessible by integration"}`)
+	if _, ok := rateLimitedUntil(forbidden.Result()); ok {
+		t.Error("expected a 403 without a rate limit not to be treated as one")
+	}
+}
+
+func TestEstimatePollRequests(t *testing.T) {
+	policy := backoffPolicy{initial: time.Second, max: time.Second * 4, multiplier: 2, jitter: 0.5}
+
+	// Polls at 0s, 1s, 3s and 7s
+	if requests := estimatePollRequests(policy, time.Second*10); requests != 4*requestsPerPoll {
+		t.Errorf("expected %d requests, got %d", 4*requestsPerPoll, requests)
+	}
+}
+
+func TestWaitRefusedWhenBudgetInsufficient(t *testing.T) {
+	server, client := newTestClient(t)
+	client.sourceApi.budget = &rateBudget{name: "insufficient"}
+	client.sourceApi.budget.update(rateLimitHeader(5000, 3, time.Now().Add(time.Hour)))
+
+	result := dispatchWithClient(context.Background(), client)
+	if result.Succeeded {
+		t.Fatal("expected the dispatch to fail")
+	}
+	if class := errorClassOf(result.err); class != errorClassRateLimit {
+		t.Errorf("expected a rate-limit error, got %v: %v", class, result.err)
+	}
+	if len(server.Dispatches()) != 0 {
+		t.Error("expected the workflow not to be dispatched")
+	}
+}
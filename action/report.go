@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v37/github"
+	"github.com/sethvargo/go-githubactions"
+)
+
+const (
+	// modeDispatch dispatches the target workflow, the default mode of the
+	// action
+	modeDispatch = "dispatch"
+	// modeReport updates the check of a dispatch from the receiving
+	// workflow, see runReport
+	modeReport = "report"
+	// modeStart and modeFinish update the check of a dispatch at the start
+	// and end of the job of the receiving workflow, see parseLifecycleReport
+	modeStart  = "start"
+	modeFinish = "finish"
+)
+
+// parseMode parses the 'mode' input, selecting what the action does
+func parseMode() (string, error) {
+	mode := strings.TrimSpace(os.Getenv("INPUT_MODE"))
+	switch mode {
+	case "":
+		return modeDispatch, nil
+	case modeDispatch, modeReport, modeStart, modeFinish:
+		return mode, nil
+	default:
+		return "", newError(errorClassValidation, "input 'mode' must be one of '%v', '%v', '%v' or '%v'", modeDispatch, modeReport, modeStart, modeFinish)
+	}
+}
+
+// checkReport is an update to the check of a dispatch, made by the
+// receiving workflow
+type checkReport struct {
+	checkId int64
+	// status is "in_progress" or "completed"
+	status     string
+	conclusion string
+	// summary replaces the summary of the check, if not empty
+	summary string
+	// text is appended to the text of the check, if not empty
+	text string
+	// outputs replace the outputs written to the text of the check, if any,
+	// see withReportedOutputs
+	outputs json.RawMessage
+	// detailsUrl replaces the details url of the check, if not empty
+	detailsUrl string
+	// keepCompleted leaves a check which has already been completed as it
+	// is, rather than updating it
+	keepCompleted bool
+}
+
+// parseReportEnvironment parses the inputs of the report, start and finish
+// modes. The check is in 'check_repository' (the repository running the
+// action by default), which the client returned is authorized against
+func parseReportEnvironment(mode string) (githubVars, inputs, checkReport, error) {
+	githubVars, err := parseGithubVars()
+	if err != nil {
+		return githubVars, inputs{}, checkReport{}, newError(errorClassValidation, "%v", err.Error())
+	}
+
+	var report checkReport
+	if mode == modeReport {
+		report, err = parseCheckReport()
+	} else {
+		report, err = parseLifecycleReport(mode, githubVars)
+	}
+	if err != nil {
+		return githubVars, inputs{}, checkReport{}, newError(errorClassValidation, "%v", err.Error())
+	}
+
+	if checkRepository := os.Getenv("INPUT_CHECK_REPOSITORY"); checkRepository != "" {
+		ownerRepoSplit := strings.Split(checkRepository, "/")
+		if len(ownerRepoSplit) != 2 || ownerRepoSplit[0] == "" || ownerRepoSplit[1] == "" {
+			return githubVars, inputs{}, checkReport{}, newError(errorClassValidation, "input 'check_repository' not formatted as owner/repo-name")
+		}
+		githubVars.repository = checkRepository
+		githubVars.repositoryOwner = ownerRepoSplit[0]
+		githubVars.repositoryName = ownerRepoSplit[1]
+	}
+
+	reportInputs, err := parseReportInputs(githubVars)
+	if err != nil {
+		return githubVars, inputs{}, checkReport{}, newError(errorClassValidation, "%v", err.Error())
+	}
+
+	return githubVars, reportInputs, report, nil
+}
+
+// parseReportInputs parses the inputs shared with the dispatch mode which
+// the report mode uses: how to authenticate and retry api calls
+func parseReportInputs(githubVars githubVars) (inputs, error) {
+	credentials, err := parseCredentials()
+	if err != nil {
+		return inputs{}, err
+	}
+
+	baseTransport, err := parseTransport()
+	if err != nil {
+		return inputs{}, err
+	}
+
+	retryPolicy, err := parseRetryPolicy()
+	if err != nil {
+		return inputs{}, err
+	}
+
+	return inputs{
+		credentials:      credentials,
+		baseTransport:    baseTransport,
+		uploadUrl:        os.Getenv("INPUT_UPLOAD_URL"),
+		targetOwner:      githubVars.repositoryOwner,
+		targetRepository: githubVars.repositoryName,
+		retryPolicy:      retryPolicy,
+	}, nil
+}
+
+// parseCheckReport parses the inputs describing the update to the check
+func parseCheckReport() (checkReport, error) {
+	checkId, err := parseCheckId()
+	if err != nil {
+		return checkReport{}, err
+	}
+
+	conclusion := strings.TrimSpace(os.Getenv("INPUT_CHECK_CONCLUSION"))
+	if conclusion != "" && !containsString(checkConclusions, conclusion) {
+		return checkReport{}, fmt.Errorf("input 'check_conclusion' must be one of %v", strings.Join(checkConclusions, ", "))
+	}
+
+	status := strings.TrimSpace(os.Getenv("INPUT_CHECK_STATUS"))
+	switch {
+	case status == "" && conclusion != "":
+		status = "completed"
+	case status == "":
+		status = "in_progress"
+	case status != "in_progress" && status != "completed":
+		return checkReport{}, errors.New("input 'check_status' must be one of 'in_progress' or 'completed'")
+	}
+	if (status == "completed") != (conclusion != "") {
+		return checkReport{}, errors.New("input 'check_conclusion' must be set if and only if 'check_status' is 'completed'")
+	}
+
+	outputs, err := parseCheckOutputsInput()
+	if err != nil {
+		return checkReport{}, err
+	}
+
+	return checkReport{
+		checkId:    checkId,
+		status:     status,
+		conclusion: conclusion,
+		summary:    os.Getenv("INPUT_CHECK_SUMMARY"),
+		text:       os.Getenv("INPUT_CHECK_TEXT"),
+		outputs:    outputs,
+	}, nil
+}
+
+// parseCheckId parses the 'check_id' input
+func parseCheckId() (int64, error) {
+	checkId, err := strconv.ParseInt(os.Getenv("INPUT_CHECK_ID"), 10, 64)
+	if err != nil || checkId < 1 {
+		return 0, errors.New("input 'check_id' must be the ID of the check to report to")
+	}
+	return checkId, nil
+}
+
+// parseCheckOutputsInput parses the 'check_outputs' input, which is
+// optional
+func parseCheckOutputsInput() (json.RawMessage, error) {
+	outputs := strings.TrimSpace(os.Getenv("INPUT_CHECK_OUTPUTS"))
+	if outputs == "" {
+		return nil, nil
+	}
+	if !json.Valid([]byte(outputs)) {
+		return nil, errors.New("input 'check_outputs' must be valid JSON")
+	}
+	return json.RawMessage(outputs), nil
+}
+
+// runReport updates the check of a dispatch from the receiving workflow in
+// the given mode and sets the state of the check as outputs of the action
+func runReport(ctx context.Context, mode string) {
+	githubVars, inputs, report, err := parseReportEnvironment(mode)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	client, err := NewGitHubClient(githubVars, inputs)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	check, err := reportCheck(ctx, client, report)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	setOutputs(map[string]string{
+		"check_id":   fmt.Sprint(check.GetID()),
+		"check_url":  check.GetHTMLURL(),
+		"status":     check.GetStatus(),
+		"conclusion": check.GetConclusion(),
+	})
+}
+
+// reportCheck applies the report to the check and returns the updated check.
+// A completed check is returned as it is if the report keeps completed
+// checks
+func reportCheck(ctx context.Context, client *GitHubClient, report checkReport) (*github.CheckRun, error) {
+	check, err := client.FetchCheckWithRetries(ctx, report.checkId)
+	if err != nil {
+		return nil, err
+	}
+	if report.keepCompleted && check.GetStatus() == "completed" {
+		githubactions.Infof("Check %v has already been %v, so it is left as it is\n", check.GetID(), describeCheckState(check))
+		return check, nil
+	}
+
+	output := &github.CheckRunOutput{
+		Title:   github.String(check.GetOutput().GetTitle()),
+		Summary: github.String(check.GetOutput().GetSummary()),
+	}
+	if output.GetTitle() == "" {
+		output.Title = github.String(check.GetName())
+	}
+	if report.summary != "" {
+		output.Summary = github.String(report.summary)
+	}
+
+	text := check.GetOutput().GetText()
+	if report.text != "" {
+		text = strings.TrimSpace(text + "\n\n" + report.text)
+	}
+	if report.outputs != nil {
+		text, err = withReportedOutputs(text, report.outputs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if text != "" {
+		output.Text = github.String(text)
+	}
+
+	opts := github.UpdateCheckRunOptions{
+		Name:   check.GetName(),
+		Status: github.String(report.status),
+		Output: output,
+	}
+	if report.detailsUrl != "" {
+		opts.DetailsURL = github.String(report.detailsUrl)
+	}
+	if report.status == "completed" {
+		opts.Conclusion = github.String(report.conclusion)
+		opts.CompletedAt = &github.Timestamp{Time: time.Now()}
+	}
+
+	updated, err := client.UpdateCheck(ctx, check.GetID(), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	githubactions.Infof("Reported check %v as %v\n", updated.GetID(), describeCheckState(updated))
+	return updated, nil
+}
+
+// describeCheckState describes the status and (if completed) conclusion of
+// a check
+func describeCheckState(check *github.CheckRun) string {
+	if check.GetStatus() != "completed" {
+		return check.GetStatus()
+	}
+	return fmt.Sprintf("%v (%v)", check.GetStatus(), check.GetConclusion())
+}
+
+// withReportedOutputs writes the outputs to the start of the text of a
+// check, in a code block annotated with the outputsStartIndicator, replacing
+// any outputs written before. The block is the first in the text and the
+// outputs are written on a single line, escaped by escapeReportedOutputs,
+// so that parseOutputsFromText reads back exactly the outputs written
+func withReportedOutputs(text string, outputs json.RawMessage) (string, error) {
+	compacted := &bytes.Buffer{}
+	if err := json.Compact(compacted, outputs); err != nil {
+		return "", fmt.Errorf("Error writing outputs: %w", err)
+	}
+	block := fmt.Sprintf("```json %v\n%v\n```", outputsStartIndicator, escapeReportedOutputs(compacted.String()))
+	return strings.TrimSpace(block + "\n\n" + withoutReportedOutputs(text)), nil
+}
+
+// escapeReportedOutputs escapes the backticks and outputsStartIndicators in
+// compact JSON outputs, which would otherwise end or begin the block they
+// are written to early. Both may only occur within JSON strings, where they
+// can be written as unicode escapes instead
+func escapeReportedOutputs(outputs string) string {
+	escaped := strings.ReplaceAll(outputs, "`", `\u0060`)
+	// Replacing an indicator may leave another which overlapped it
+	for strings.Contains(escaped, outputsStartIndicator) {
+		escaped = strings.ReplaceAll(escaped, outputsStartIndicator, `\u002d`+outputsStartIndicator[1:])
+	}
+	return escaped
+}
+
+// withoutReportedOutputs removes the block written by withReportedOutputs
+// from the start of the text of a check, if any
+func withoutReportedOutputs(text string) string {
+	prefix := "```json " + outputsStartIndicator + "\n"
+	if !strings.HasPrefix(text, prefix) {
+		return text
+	}
+	end := strings.Index(text[len(prefix):], "\n```")
+	if end == -1 {
+		return text
+	}
+	return strings.TrimSpace(text[len(prefix)+end+len("\n```"):])
+}
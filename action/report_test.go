@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReportCheck(t *testing.T) {
+	server, client := newTestClient(t)
+	ctx := context.Background()
+
+	checkRun, err := client.CreateCheck(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error creating the check: %v", err)
+	}
+
+	check, err := reportCheck(ctx, client, checkReport{checkId: checkRun.GetID(), status: "in_progress", text: "Deploying..."})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if check.GetStatus() != "in_progress" || check.GetOutput().GetText() != "Deploying..." {
+		t.Errorf("expected the check to be in progress with the text reported, got %v: %q", check.GetStatus(), check.GetOutput().GetText())
+	}
+
+	// Outputs reported again replace those reported before. Notes include
+	// text which would end or begin the block of outputs, unless escaped
+	notes := `---- BEGIN CHECK OUTPUT ---- BEGIN CHECK OUTPUT ----\n---- END CHECK OUTPUT notes ----`
+	expectedNotes := "---- BEGIN CHECK OUTPUT ---- BEGIN CHECK OUTPUT ----\n---- END CHECK OUTPUT notes ----"
+	for _, outputs := range []string{
+		`{"version": "1.2.2"}`,
+		`{"version": "1.2.3", "notes": "` + "```" + notes + "```" + `"}`,
+	} {
+		check, err = reportCheck(ctx, client, checkReport{
+			checkId:    checkRun.GetID(),
+			status:     "completed",
+			conclusion: "success",
+			summary:    "Deployed",
+			text:       "Deployed!",
+			outputs:    json.RawMessage(outputs),
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	check = server.CheckRun(checkRun.GetID())
+	if check.GetConclusion() != "success" || check.GetOutput().GetSummary() != "Deployed" {
+		t.Errorf("expected the check to be completed with the summary reported, got %v: %q", check.GetConclusion(), check.GetOutput().GetSummary())
+	}
+	text := check.GetOutput().GetText()
+	if !strings.HasSuffix(text, "Deploying...\n\nDeployed!\n\nDeployed!") || strings.Count(text, outputsStartIndicator) != 1 {
+		t.Errorf("expected the text to be appended to with a single outputs block, got %q", text)
+	}
+
+	for _, rawOutputs := range []string{parseOutputsFromText(&text), mustParseCheckOutputs(t, text)} {
+		var outputs map[string]string
+		if err := json.Unmarshal([]byte(rawOutputs), &outputs); err != nil {
+			t.Fatalf("expected the outputs to be read back as JSON, got %q: %v", rawOutputs, err)
+		}
+		if outputs["version"] != "1.2.3" || outputs["notes"] != "```"+expectedNotes+"```" {
+			t.Errorf("expected the outputs reported last to be read back, got %v", outputs)
+		}
+	}
+}
+
+func mustParseCheckOutputs(t *testing.T, text string) string {
+	outputs, err := parseCheckOutputs(&text)
+	if err != nil {
+		t.Fatalf("unexpected error parsing outputs: %v", err)
+	}
+	return outputs
+}
+
+func TestParseCheckReport(t *testing.T) {
+	cases := []struct {
+		status         string
+		conclusion     string
+		expectedStatus string
+		valid          bool
+	}{
+		{"", "", "in_progress", true},
+		{"", "failure", "completed", true},
+		{"completed", "success", "completed", true},
+		{"completed", "", "", false},
+		{"in_progress", "success", "", false},
+		{"queued", "", "", false},
+		{"", "passed", "", false},
+	}
+
+	os.Setenv("INPUT_CHECK_ID", "1001")
+	defer os.Unsetenv("INPUT_CHECK_ID")
+	defer os.Unsetenv("INPUT_CHECK_STATUS")
+	defer os.Unsetenv("INPUT_CHECK_CONCLUSION")
+	for _, c := range cases {
+		os.Setenv("INPUT_CHECK_STATUS", c.status)
+		os.Setenv("INPUT_CHECK_CONCLUSION", c.conclusion)
+
+		report, err := parseCheckReport()
+		if c.valid != (err == nil) {
+			t.Errorf("expected status %q and conclusion %q to be valid=%v, got error: %v", c.status, c.conclusion, c.valid, err)
+		}
+		if err == nil && report.status != c.expectedStatus {
+			t.Errorf("expected status %q and conclusion %q to report %v, got %v", c.status, c.conclusion, c.expectedStatus, report.status)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sethvargo/go-githubactions"
+)
+
+const (
+	defaultRetryMaxAttempts         = 4
+	defaultRetryInitialDelaySeconds = 1
+	defaultRetryMaxDelaySeconds     = 30
+)
+
+// retryPolicy configures how calls to the GitHub api are retried
+type retryPolicy struct {
+	maxAttempts  int
+	initialDelay time.Duration
+	maxDelay     time.Duration
+}
+
+// retryOptions adjust the retry policy for a single operation
+type retryOptions struct {
+	// retryable overrides which errors the operation is retried for, see
+	// isRetryableError for the default
+	retryable func(error) bool
+	// applied is called before retrying an operation which is not
+	// idempotent, such as a dispatch, and reports whether the failed attempt
+	// took effect regardless, in which case it is not retried
+	applied func(ctx context.Context) (bool, error)
+}
+
+// retry performs an api operation, retrying it with exponential backoff
+// (or as long as the api asks to wait) while it fails with a retryable
+// error, up to the maximum number of attempts of the retry policy. Each
+// attempt is bounded by the api timeout of the client
+func (client *GitHubClient) retry(ctx context.Context, description string, options retryOptions, operation func(ctx context.Context) error) error {
+	policy := client.inputs.retryPolicy
+	retryable := options.retryable
+	if retryable == nil {
+		retryable = isRetryableError
+	}
+	delays := newBackoff(backoffPolicy{initial: policy.initialDelay, max: policy.maxDelay, multiplier: 2, jitter: 0.2})
+
+	for attempt := 1; ; attempt++ {
+		err := client.attempt(ctx, operation)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil || !retryable(err) || attempt >= policy.maxAttempts {
+			return err
+		}
+
+		// Waits the api asks for which are longer than the policy allows, such
+		// as for an exhausted rate limit to reset, are left to the caller
+		delay := delays.next()
+		if retryAfter, ok := retryDelay(err); ok {
+			if retryAfter > policy.maxDelay {
+				return err
+			}
+			if retryAfter > delay {
+				delay = retryAfter
+			}
+		}
+		if !deadlineAllows(ctx, delay) {
+			return err
+		}
+		githubactions.Warningf("Error %v (attempt %d of %d), retrying in %v: %v", description, attempt, policy.maxAttempts, delay.Round(time.Millisecond), err.Error())
+		if sleepContext(ctx, delay) != nil {
+			return err
+		}
+
+		if options.applied != nil {
+			applied, appliedErr := options.applied(ctx)
+			if appliedErr != nil {
+				return fmt.Errorf("%w (and unable to tell whether it took effect: %v)", err, appliedErr.Error())
+			}
+			if applied {
+				githubactions.Infof("The failed attempt at %v took effect, not retrying\n", description)
+				return nil
+			}
+		}
+	}
+}
+
+// attempt performs a single attempt of an api operation, bounded by the
+// api timeout of the client
+func (client *GitHubClient) attempt(ctx context.Context, operation func(ctx context.Context) error) error {
+	apiTimeoutCtx, cancel := context.WithTimeout(ctx, client.apiTimeoutDuration)
+	defer cancel()
+
+	return operation(apiTimeoutCtx)
+}
+
+// deadlineAllows reports whether the context allows waiting for the given
+// duration before its deadline
+func deadlineAllows(ctx context.Context, duration time.Duration) bool {
+	deadline, ok := ctx.Deadline()
+	return !ok || time.Now().Add(duration).Before(deadline)
+}
+
+// isRetryableError reports whether an api call failing with the error may
+// succeed if retried: server and network errors, rate limits and attempts
+// which timed out
+func isRetryableError(err error) bool {
+	switch errorClassOf(err) {
+	case errorClassTransient, errorClassRateLimit, errorClassTimeout:
+		return true
+	default:
+		return false
+	}
+}
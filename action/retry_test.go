@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DrizlyInc/workflow-dispatch-action/action/m/v2/internal/fakegithub"
+)
+
+// completeOnDispatch scripts the check of every dispatch to complete
+// successfully
+func completeOnDispatch(t *testing.T, server *fakegithub.Server) {
+	server.OnDispatch = func(dispatch fakegithub.Dispatch) {
+		server.ScriptCheckRun(dispatchedCheckId(t, dispatch), fakegithub.CheckRunUpdate{Status: "completed", Conclusion: "success"})
+	}
+}
+
+func TestRetryTransientErrors(t *testing.T) {
+	server, client := newTestClient(t)
+	completeOnDispatch(t, server)
+	server.FailRequests("GET", "/repos/target-owner/target", 503, 1)
+	server.FailRequests("POST", "/repos/target-owner/target/actions/workflows/", 502, 2)
+
+	result := dispatchWithClient(context.Background(), client)
+	if !result.Succeeded {
+		t.Fatalf("expected the dispatch to succeed after retrying, got error: %v", result.err)
+	}
+	if dispatches := len(server.Dispatches()); dispatches != 1 {
+		t.Errorf("expected 1 dispatch, got %d", dispatches)
+	}
+}
+
+func TestRetryDoesNotRepeatAppliedRequests(t *testing.T) {
+	server, client := newTestClient(t)
+	completeOnDispatch(t, server)
+	server.FailResponses("POST", "/repos/source-owner/source/check-runs", 502, 1)
+	server.FailResponses("POST", "/repos/target-owner/target/actions/workflows/", 502, 1)
+	// The run of the dispatch is only listed after a few lookups, next to
+	// a run which does not belong to it
+	server.DelayWorkflowRuns(3)
+	server.AddWorkflowRun("target-owner", "target", 42, "deploy")
+
+	result := dispatchWithClient(context.Background(), client)
+	if !result.Succeeded {
+		t.Fatalf("expected the dispatch to succeed, got error: %v", result.err)
+	}
+	if dispatches := len(server.Dispatches()); dispatches != 1 {
+		t.Errorf("expected the dispatch not to be repeated, got %d dispatches", dispatches)
+	}
+	checksCreated := 0
+	for _, request := range server.Requests() {
+		if request == "POST /repos/source-owner/source/check-runs" {
+			checksCreated++
+		}
+	}
+	if checksCreated != 1 {
+		t.Errorf("expected the check not to be created again, got %d requests creating it", checksCreated)
+	}
+}
+
+func TestRetryRepeatsUnappliedDispatch(t *testing.T) {
+	server, client := newTestClient(t)
+	completeOnDispatch(t, server)
+	client.inputs.runLookupTimeout = time.Millisecond * 100
+	server.FailRequests("POST", "/repos/target-owner/target/actions/workflows/", 502, 1)
+	// A run which does not belong to the dispatch must not be mistaken for
+	// it, even as the only candidate
+	unrelatedRunId := server.AddWorkflowRun("target-owner", "target", 42, "deploy")
+
+	result := dispatchWithClient(context.Background(), client)
+	if !result.Succeeded {
+		t.Fatalf("expected the dispatch to succeed, got error: %v", result.err)
+	}
+	if dispatches := len(server.Dispatches()); dispatches != 1 {
+		t.Errorf("expected the dispatch to be repeated once, got %d dispatches", dispatches)
+	}
+	if result.RunId == unrelatedRunId || !result.runVerified {
+		t.Errorf("expected the dispatched run to be identified, got run %v (verified=%v)", result.RunId, result.runVerified)
+	}
+}
+
+func TestRetryDoesNotRepeatUncorrelatedDispatch(t *testing.T) {
+	server, client := newTestClient(t)
+	completeOnDispatch(t, server)
+	server.AddWorkflow("target-owner", "target", ".github/workflows/deploy.yml", strings.Replace(testWorkflow, "run-name: deploy [${{ inputs.check_id }}]\n", "", 1), 42)
+	server.FailResponses("POST", "/repos/target-owner/target/actions/workflows/", 502, 1)
+
+	started := time.Now()
+	result := dispatchWithClient(context.Background(), client)
+	if result.Succeeded || result.err == nil || !strings.Contains(result.err.Error(), "unable to tell whether it took effect") {
+		t.Fatalf("expected the dispatch to fail without being retried, got %v", result.err)
+	}
+	if dispatches := len(server.Dispatches()); dispatches != 1 {
+		t.Errorf("expected the dispatch not to be repeated, got %d dispatches", dispatches)
+	}
+	if elapsed := time.Since(started); elapsed > client.inputs.runLookupTimeout {
+		t.Errorf("expected the dispatch to fail without looking for its run, took %v", elapsed)
+	}
+}
+
+func TestRetryStopsAtNonRetryableErrors(t *testing.T) {
+	_, client := newTestClient(t)
+
+	attempts := 0
+	err := client.retry(context.Background(), "testing", retryOptions{}, func(ctx context.Context) error {
+		attempts++
+		if attempts == 1 {
+			return newError(errorClassTransient, "temporary")
+		}
+		return newError(errorClassValidation, "invalid")
+	})
+	if errorClassOf(err) != errorClassValidation {
+		t.Errorf("expected the validation error, got %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("expected 2 attempts, got %d", attempts)
+	}
+
+	attempts = 0
+	err = client.retry(context.Background(), "testing", retryOptions{}, func(ctx context.Context) error {
+		attempts++
+		return &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("network unreachable")}
+	})
+	if err == nil || attempts != client.inputs.retryPolicy.maxAttempts {
+		t.Errorf("expected %d attempts before giving up, got %d (%v)", client.inputs.retryPolicy.maxAttempts, attempts, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+	attempts = 0
+	_ = client.retry(ctx, "testing", retryOptions{}, func(ctx context.Context) error {
+		attempts++
+		return newError(errorClassTransient, "temporary")
+	})
+	if attempts != 1 {
+		t.Errorf("expected no retries once the context is done, got %d attempts", attempts)
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sethvargo/go-githubactions"
+)
+
+const (
+	// redactedValue replaces the values of workflow inputs redacted from the
+	// job summary
+	redactedValue = "***"
+	// maxSummaryValueLength and maxSummaryOutputs bound the outputs shown in
+	// the job summary, which GitHub limits to 1MiB per step
+	maxSummaryValueLength = 256
+	maxSummaryOutputs     = 50
+)
+
+// sensitiveInputWords are the words which, as a segment of the name of a
+// workflow input, always redact it from the job summary, see
+// isSensitiveInputName
+var sensitiveInputWords = map[string]bool{
+	"secret": true, "secrets": true, "token": true, "tokens": true,
+	"password": true, "passwd": true, "passphrase": true,
+	"credential": true, "credentials": true, "creds": true,
+	"auth": true, "cert": true, "certificate": true,
+	"apikey": true, "privatekey": true,
+}
+
+// sensitiveKeyQualifiers are the words which make a "key" segment following
+// them sensitive, e.g. api_key or PRIVATE-KEY but not cache_key
+var sensitiveKeyQualifiers = map[string]bool{
+	"private": true, "api": true, "access": true, "secret": true,
+	"signing": true, "ssh": true, "encryption": true, "client": true, "deploy": true,
+}
+
+// isSensitiveInputName reports whether the name of a workflow input marks
+// it as a secret. The name is split into segments on underscores and
+// hyphens, so that only whole words count: auth_header is sensitive, while
+// author is not. Inputs named otherwise are covered by redact_inputs
+func isSensitiveInputName(name string) bool {
+	segments := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return r == '_' || r == '-'
+	})
+	for i, segment := range segments {
+		if sensitiveInputWords[segment] {
+			return true
+		}
+		if segment == "key" && i > 0 && sensitiveKeyQualifiers[segments[i-1]] {
+			return true
+		}
+	}
+	return false
+}
+
+// secretActionInputs are the inputs of the action whose values are redacted
+// wherever they appear in the workflow inputs
+var secretActionInputs = []string{"token", "private_key", "client_key", "webhook_secret"}
+
+// dispatchSummary records the details of a dispatch reported in the job
+// summary, see recordSummary
+type dispatchSummary struct {
+	ref      string
+	workflow string
+	// inputs are the workflow inputs sent, and matrix the inputs of the
+	// matrix entry, with sensitive values redacted
+	inputs map[string]interface{}
+	matrix map[string]interface{}
+	// queued is the time from the dispatch until the first job of the run
+	// started, and running the time from then until its last job completed,
+	// where known
+	queued  *time.Duration
+	running *time.Duration
+}
+
+// parseRedactInputs parses the redact_inputs input, listing the names (or
+// glob patterns of the names) of workflow inputs to redact from the job
+// summary in addition to those named like secrets, see isSensitiveInputName
+func parseRedactInputs() ([]string, error) {
+	patterns := []string{}
+	for _, pattern := range strings.FieldsFunc(os.Getenv("INPUT_REDACT_INPUTS"), isListSeparator) {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("input 'redact_inputs' contains an invalid pattern %q: %w", pattern, err)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// secretValues returns the values of the secret inputs of the action, see
+// secretActionInputs
+func secretValues() []string {
+	values := []string{}
+	for _, name := range secretActionInputs {
+		if value := strings.TrimSpace(os.Getenv("INPUT_" + strings.ToUpper(name))); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// redactWorkflowInputs returns a copy of the workflow inputs with the value
+// of each sensitive input replaced: inputs named like a secret or matching
+// one of the patterns, and inputs whose value contains a secret given to
+// the action itself
+func redactWorkflowInputs(workflowInputs map[string]interface{}, patterns []string, secrets []string) map[string]interface{} {
+	redacted := map[string]interface{}{}
+	for name, value := range workflowInputs {
+		redacted[name] = value
+		if isSensitiveInputName(name) {
+			redacted[name] = redactedValue
+			continue
+		}
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, name); ok {
+				redacted[name] = redactedValue
+			}
+		}
+
+		rawValue, _ := json.Marshal(value)
+		for _, secret := range secrets {
+			if strings.Contains(fmt.Sprint(value), secret) || strings.Contains(string(rawValue), secret) {
+				redacted[name] = redactedValue
+			}
+		}
+	}
+	return redacted
+}
+
+// newDispatchSummary describes a dispatch by its inputs and (once resolved)
+// target workflow, with sensitive values redacted
+func newDispatchSummary(inputs inputs, workflow string) *dispatchSummary {
+	summary := &dispatchSummary{
+		ref:      inputs.targetRef,
+		workflow: workflow,
+		inputs:   redactWorkflowInputs(inputs.workflowInputs, inputs.redactInputs, inputs.secretValues),
+	}
+	if inputs.matrixEntry != nil {
+		summary.matrix = redactWorkflowInputs(inputs.matrixEntry, inputs.redactInputs, inputs.secretValues)
+	}
+	return summary
+}
+
+// recordSummary records the details of a dispatch for the job summary once
+// it has finished. The phases of the workflow run are derived from the
+// times its jobs started and completed, which are left out if the jobs
+// cannot be listed
+func recordSummary(client *GitHubClient, result *dispatchResult) {
+	summary := newDispatchSummary(client.inputs, client.workflow.path)
+	result.summary = summary
+	if result.RunId == 0 || result.dispatchedAt.IsZero() {
+		return
+	}
+
+	// The context of the dispatch may already be cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), interruptCleanupTimeout)
+	defer cancel()
+
+	jobs, err := client.ListWorkflowJobs(ctx, result.RunId)
+	if err != nil {
+		githubactions.Warningf("Error listing the jobs of workflow run %v for the job summary: %v", result.RunId, err.Error())
+		return
+	}
+
+	var firstStarted, lastCompleted time.Time
+	completed := len(jobs) > 0
+	for _, job := range jobs {
+		if job.StartedAt != nil && job.GetStatus() != "queued" && (firstStarted.IsZero() || job.StartedAt.Before(firstStarted)) {
+			firstStarted = job.StartedAt.Time
+		}
+		if job.GetStatus() != "completed" || job.CompletedAt == nil {
+			completed = false
+		} else if job.CompletedAt.After(lastCompleted) {
+			lastCompleted = job.CompletedAt.Time
+		}
+	}
+	if firstStarted.IsZero() {
+		return
+	}
+
+	queued := firstStarted.Sub(result.dispatchedAt)
+	if queued < 0 {
+		queued = 0
+	}
+	summary.queued = &queued
+	if completed {
+		running := lastCompleted.Sub(firstStarted)
+		summary.running = &running
+	}
+}
+
+// writeDispatchSummary appends a report of the dispatches to the job
+// summary, if the runner provides one (GITHUB_STEP_SUMMARY)
+func writeDispatchSummary(results []dispatchResult) {
+	summaryFile := os.Getenv("GITHUB_STEP_SUMMARY")
+	if summaryFile == "" {
+		return
+	}
+
+	f, err := os.OpenFile(summaryFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err == nil {
+		_, err = f.WriteString(formatDispatchSummary(results))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		githubactions.Warningf("Error writing the job summary: %v", err.Error())
+	}
+}
+
+// formatDispatchSummary formats a Markdown report of the dispatches, with a
+// section for each describing its target, the inputs sent, its check and
+// workflow run, how long it took and its outputs
+func formatDispatchSummary(results []dispatchResult) string {
+	var report strings.Builder
+	report.WriteString("## Workflow dispatch\n\n")
+
+	for _, result := range results {
+		status := "✅"
+		if !result.Succeeded {
+			status = "❌"
+		}
+		// The matrix entry is only described once redacted, see
+		// newDispatchSummary
+		summary := result.summary
+		title := result.Repository
+		if summary != nil && summary.matrix != nil {
+			title = fmt.Sprintf("%v (%v)", title, describeMatrixEntry(summary.matrix))
+		}
+		fmt.Fprintf(&report, "### %v %v\n\n", status, escapeSummaryText(title))
+
+		rows := [][2]string{{"Repository", escapeSummaryText(result.Repository)}}
+		if summary != nil && summary.ref != "" {
+			rows = append(rows, [2]string{"Ref", escapeSummaryText(summary.ref)})
+		}
+		if summary != nil && summary.workflow != "" {
+			rows = append(rows, [2]string{"Workflow", escapeSummaryText(summary.workflow)})
+		}
+		if result.CheckUrl != "" {
+			rows = append(rows, [2]string{"Check", fmt.Sprintf("[%v](%v)", result.CheckId, result.CheckUrl)})
+		}
+		if result.RunUrl != "" {
+			rows = append(rows, [2]string{"Workflow run", fmt.Sprintf("[%v](%v)", result.RunId, result.RunUrl)})
+		}
+		if result.Conclusion != "" {
+			rows = append(rows, [2]string{"Conclusion", escapeSummaryText(result.Conclusion)})
+		} else if result.Status != "" {
+			rows = append(rows, [2]string{"Status", escapeSummaryText(result.Status)})
+		}
+		if summary != nil && summary.queued != nil {
+			rows = append(rows, [2]string{"Queued", summary.queued.Round(time.Second).String()})
+		}
+		if summary != nil && summary.running != nil {
+			rows = append(rows, [2]string{"Running", summary.running.Round(time.Second).String()})
+		}
+		if result.DurationSeconds != nil {
+			rows = append(rows, [2]string{"Check duration", (time.Second * time.Duration(*result.DurationSeconds)).String()})
+		}
+		if result.Error != "" {
+			rows = append(rows, [2]string{"Error", escapeSummaryText(result.Error)})
+		}
+		writeSummaryTable(&report, [2]string{"", ""}, rows)
+
+		if summary != nil && len(summary.inputs) > 0 {
+			rawInputs, _ := json.MarshalIndent(summary.inputs, "", "  ")
+			fmt.Fprintf(&report, "<details><summary>Inputs</summary>\n\n```json\n%v\n```\n\n</details>\n\n", strings.ReplaceAll(string(rawInputs), "```", "` ` `"))
+		}
+
+		writeSummaryOutputs(&report, result)
+	}
+	return report.String()
+}
+
+// writeSummaryOutputs renders the outputs of a dispatch as a table of the
+// top-level keys of a JSON object output, or as it is otherwise
+func writeSummaryOutputs(report *strings.Builder, result dispatchResult) {
+	if strings.TrimSpace(result.rawOutput) == "" {
+		return
+	}
+	report.WriteString("#### Outputs\n\n")
+
+	var outputs map[string]json.RawMessage
+	if err := json.Unmarshal(result.Output, &outputs); err != nil || outputs == nil {
+		fmt.Fprintf(report, "```\n%v\n```\n\n", strings.ReplaceAll(truncateSummaryValue(result.rawOutput), "```", "` ` `"))
+		return
+	}
+
+	names := make([]string, 0, len(outputs))
+	for name := range outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rows := [][2]string{}
+	for i, name := range names {
+		if i == maxSummaryOutputs {
+			rows = append(rows, [2]string{"…", fmt.Sprintf("%d more outputs, see the `output` output", len(names)-maxSummaryOutputs)})
+			break
+		}
+		var value string
+		if err := json.Unmarshal(outputs[name], &value); err != nil {
+			value = string(outputs[name])
+		}
+		rows = append(rows, [2]string{escapeSummaryText(name), escapeSummaryText(truncateSummaryValue(value))})
+	}
+	writeSummaryTable(report, [2]string{"Output", "Value"}, rows)
+}
+
+// writeSummaryTable renders rows of two columns as a Markdown table
+func writeSummaryTable(report *strings.Builder, header [2]string, rows [][2]string) {
+	fmt.Fprintf(report, "| %v | %v |\n| --- | --- |\n", header[0], header[1])
+	for _, row := range rows {
+		fmt.Fprintf(report, "| %v | %v |\n", row[0], row[1])
+	}
+	report.WriteString("\n")
+}
+
+// escapeSummaryText escapes text for a cell of a Markdown table, so that
+// values from the target workflow cannot break out of it
+func escapeSummaryText(text string) string {
+	text = html.EscapeString(text)
+	text = strings.ReplaceAll(text, "|", "\\|")
+	text = strings.ReplaceAll(text, "\r\n", "<br>")
+	return strings.ReplaceAll(text, "\n", "<br>")
+}
+
+// truncateSummaryValue shortens a value shown in the job summary to
+// maxSummaryValueLength characters
+func truncateSummaryValue(value string) string {
+	runes := []rune(value)
+	if len(runes) <= maxSummaryValueLength {
+		return value
+	}
+	return string(runes[:maxSummaryValueLength]) + "…"
+}
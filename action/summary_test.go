@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DrizlyInc/workflow-dispatch-action/action/m/v2/internal/fakegithub"
+	"github.com/google/go-github/v37/github"
+)
+
+func TestRedactWorkflowInputs(t *testing.T) {
+	workflowInputs := map[string]interface{}{
+		"environment":    "staging",
+		"api_token":      "abc123",
+		"database_url":   "postgres://db",
+		"notes":          "deployed with s3cr3t-value",
+		"replicas":       3,
+		"check_id":       "1001",
+		"SIGNING_KEY_ID": "key-1",
+		"db-password":    "hunter2",
+		"cache_key":      "deps-v1",
+		"author":         "octocat",
+		"certify":        true,
+	}
+
+	redacted := redactWorkflowInputs(workflowInputs, []string{"database_*"}, []string{"s3cr3t-value"})
+	expected := map[string]interface{}{
+		"environment":    "staging",
+		"api_token":      redactedValue,
+		"database_url":   redactedValue,
+		"notes":          redactedValue,
+		"replicas":       3,
+		"check_id":       "1001",
+		"SIGNING_KEY_ID": redactedValue,
+		"db-password":    redactedValue,
+		"cache_key":      "deps-v1",
+		"author":         "octocat",
+		"certify":        true,
+	}
+	if !reflect.DeepEqual(redacted, expected) {
+		t.Errorf("expected %v, got %v", expected, redacted)
+	}
+	if workflowInputs["api_token"] != "abc123" {
+		t.Error("expected the workflow inputs sent not to be modified")
+	}
+}
+
+func TestDispatchStepSummary(t *testing.T) {
+	server, client := newTestClient(t)
+	client.inputs.stepSummary = true
+	client.inputs.redactInputs = []string{"env*"}
+
+	summaryFile := filepath.Join(t.TempDir(), "step_summary")
+	os.Setenv("GITHUB_STEP_SUMMARY", summaryFile)
+	defer os.Unsetenv("GITHUB_STEP_SUMMARY")
+
+	server.OnDispatch = func(dispatch fakegithub.Dispatch) {
+		startedAt := time.Now().Add(time.Second * 5)
+		server.ScriptWorkflowRun(dispatch.RunID, fakegithub.WorkflowRunUpdate{Status: "completed", Conclusion: "success", Jobs: []*github.WorkflowJob{{
+			ID:          github.Int64(7),
+			Name:        github.String("deploy"),
+			Status:      github.String("completed"),
+			Conclusion:  github.String("success"),
+			StartedAt:   &github.Timestamp{Time: startedAt},
+			CompletedAt: &github.Timestamp{Time: startedAt.Add(time.Second * 90)},
+		}}})
+		server.ScriptCheckRun(dispatchedCheckId(t, dispatch),
+			fakegithub.CheckRunUpdate{Status: "in_progress"},
+			fakegithub.CheckRunUpdate{
+				Status:     "completed",
+				Conclusion: "success",
+				Text:       "```json " + outputsStartIndicator + "\n{\"version\": \"1.2.3\", \"notes\": \"a|b\\n<b>c</b>\", \"replicas\": 3}\n```",
+			},
+		)
+	}
+
+	result := dispatchWithClient(context.Background(), client)
+	if !result.Succeeded {
+		t.Fatalf("expected the dispatch to succeed, got error: %v", result.err)
+	}
+	writeDispatchSummary([]dispatchResult{result})
+
+	contents, err := os.ReadFile(summaryFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	summary := string(contents)
+	for _, expected := range []string{
+		"### ✅ target-owner/target\n",
+		"| Ref | main |",
+		"| Workflow | .github/workflows/deploy.yml |",
+		fmt.Sprintf("| Check | [%d](%v) |", result.CheckId, result.CheckUrl),
+		fmt.Sprintf("| Workflow run | [%d](%v) |", result.RunId, result.RunUrl),
+		"| Conclusion | success |",
+		"| Queued | 5s |",
+		"| Running | 1m30s |",
+		`"environment": "***"`,
+		`"github_sha": "abc123"`,
+		fmt.Sprintf(`"check_id": "%d"`, result.CheckId),
+		"| notes | a\\|b<br>&lt;b&gt;c&lt;/b&gt; |",
+		"| replicas | 3 |",
+		"| version | 1.2.3 |",
+	} {
+		if !strings.Contains(summary, expected) {
+			t.Errorf("expected the summary to contain %q, got:\n%v", expected, summary)
+		}
+	}
+	if strings.Contains(summary, "staging") {
+		t.Errorf("expected the environment to be redacted, got:\n%v", summary)
+	}
+}
+
+func TestFormatDispatchSummaryRedactsMatrix(t *testing.T) {
+	_, client := newTestClient(t)
+	client.inputs.redactInputs = []string{"region"}
+	client.inputs.secretValues = []string{"s3cr3t-value"}
+	matrixInputs := client.inputs.forMatrixEntry(map[string]interface{}{"region": "us-east-1", "notes": "s3cr3t-value", "tier": "gold"})
+
+	// A dispatch which failed before its client was created is summarized
+	// from its inputs alone
+	results := []dispatchResult{
+		{Repository: "target-owner/target", Matrix: matrixInputs.matrixEntry, Error: "failed", summary: newDispatchSummary(matrixInputs, "")},
+		{Repository: "target-owner/other", Matrix: matrixInputs.matrixEntry, Error: "failed"},
+	}
+	summary := formatDispatchSummary(results)
+
+	expected := "### ❌ target-owner/target (notes=***, region=***, tier=gold)\n"
+	if !strings.Contains(summary, expected) {
+		t.Errorf("expected the summary to contain %q, got:\n%v", expected, summary)
+	}
+	if !strings.Contains(summary, "### ❌ target-owner/other\n") {
+		t.Errorf("expected a matrix entry without a summary not to be described, got:\n%v", summary)
+	}
+	for _, secret := range []string{"us-east-1", "s3cr3t-value"} {
+		if strings.Contains(summary, secret) {
+			t.Errorf("expected %q to be redacted, got:\n%v", secret, summary)
+		}
+	}
+}
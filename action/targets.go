@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/google/go-github/v37/github"
+)
+
+const defaultMaxParallel = 4
+
+// target identifies a repository to dispatch the workflow to. Before
+// expansion, the repository may be a glob pattern
+type target struct {
+	owner      string
+	repository string
+}
+
+func (t target) String() string {
+	return fmt.Sprintf("%v/%v", t.owner, t.repository)
+}
+
+// isPattern reports whether the repository of the target is a glob pattern
+// which must be expanded by listing the repositories of the owner
+func (t target) isPattern() bool {
+	return strings.ContainsAny(t.repository, `*?[\`)
+}
+
+// isFanOut reports whether the inputs may dispatch to more than a single
+// repository, in which case a check is created per target and results are
+// reported as an aggregate
+func (inputs inputs) isFanOut() bool {
+	return len(inputs.targets) > 1 || inputs.targets[0].isPattern() || len(inputs.targetTopics) > 0
+}
+
+// forTarget returns a copy of the inputs scoped to a single target
+// repository, with a copy of the workflow inputs so that concurrent
+// dispatches do not share state
+func (inputs inputs) forTarget(t target) inputs {
+	scoped := inputs
+	scoped.targetOwner = t.owner
+	scoped.targetRepository = t.repository
+	scoped.targets = []target{t}
+	scoped.targetTopics = nil
+	scoped.workflowInputs = map[string]interface{}{}
+	for key, value := range inputs.workflowInputs {
+		scoped.workflowInputs[key] = value
+	}
+	if inputs.isFanOut() {
+		scoped.checkName = fmt.Sprintf("%v (%v)", inputs.checkName, t)
+	}
+	return scoped
+}
+
+// expandTargets resolves the targets given as input into the concrete list
+// of repositories to dispatch to, expanding glob patterns and applying the
+// topic filter. Duplicate repositories are removed.
+func expandTargets(ctx context.Context, githubVars githubVars, inputs inputs) ([]target, error) {
+	expanded := []target{}
+	seen := map[string]bool{}
+
+	for _, t := range inputs.targets {
+		matches := []target{t}
+		if t.isPattern() || len(inputs.targetTopics) > 0 {
+			var err error
+			matches, err = listMatchingRepositories(ctx, githubVars, inputs, t)
+			if err != nil {
+				return nil, fmt.Errorf("Error expanding target repositories for %v: %w", t, err)
+			}
+		}
+
+		for _, match := range matches {
+			key := strings.ToLower(match.String())
+			if !seen[key] {
+				seen[key] = true
+				expanded = append(expanded, match)
+			}
+		}
+	}
+
+	if len(expanded) == 0 {
+		return nil, errors.New("No repositories matched input 'target_repository' and 'target_topics'")
+	}
+
+	return expanded, nil
+}
+
+// listMatchingRepositories lists the (non-archived) repositories of the
+// owner of the given target whose names match its pattern and which are
+// tagged with all of the topics given as input
+func listMatchingRepositories(ctx context.Context, githubVars githubVars, inputs inputs, pattern target) ([]target, error) {
+	api, err := newApiClient(ctx, githubVars, inputs, pattern.owner, "")
+	if err != nil {
+		return nil, err
+	}
+
+	repositories, err := listOwnerRepositories(ctx, api, pattern.owner)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := []target{}
+	for _, repository := range repositories {
+		if repository.GetArchived() {
+			continue
+		}
+		if matched, _ := path.Match(pattern.repository, repository.GetName()); !matched {
+			continue
+		}
+		if !hasAllTopics(repository, inputs.targetTopics) {
+			continue
+		}
+		matches = append(matches, target{owner: pattern.owner, repository: repository.GetName()})
+	}
+
+	return matches, nil
+}
+
+// listOwnerRepositories lists every repository belonging to an owner,
+// which may be either an organization or a user
+func listOwnerRepositories(ctx context.Context, api *githubApi, owner string) ([]*github.Repository, error) {
+	var allRepositories []*github.Repository
+
+	orgOpt := &github.RepositoryListByOrgOptions{
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		repositories, resp, err := api.Repositories.ListByOrg(ctx, owner, orgOpt)
+		if err != nil {
+			if resp != nil && resp.StatusCode == http.StatusNotFound {
+				break
+			}
+			return nil, err
+		}
+		allRepositories = append(allRepositories, repositories...)
+		if resp.NextPage == 0 {
+			return allRepositories, nil
+		}
+		orgOpt.Page = resp.NextPage
+	}
+
+	// The owner is not an organization, so list the repositories of the user
+	userOpt := &github.RepositoryListOptions{
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		repositories, resp, err := api.Repositories.List(ctx, owner, userOpt)
+		if err != nil {
+			return nil, err
+		}
+		allRepositories = append(allRepositories, repositories...)
+		if resp.NextPage == 0 {
+			return allRepositories, nil
+		}
+		userOpt.Page = resp.NextPage
+	}
+}
+
+// hasAllTopics reports whether the repository is tagged with every one of
+// the given topics
+func hasAllTopics(repository *github.Repository, topics []string) bool {
+	for _, topic := range topics {
+		found := false
+		for _, repositoryTopic := range repository.Topics {
+			if strings.EqualFold(topic, repositoryTopic) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseTargets(t *testing.T) {
+
+	targets, err := parseTargets("my-org/service-*,\nmy-org/api\n\nother-org/tool\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"my-org/service-*", "my-org/api", "other-org/tool"}
+	if len(targets) != len(expected) {
+		t.Fatalf("expected %d targets, got %d", len(expected), len(targets))
+	}
+	for i, target := range targets {
+		if target.String() != expected[i] {
+			t.Errorf("expected %v, got %v", expected[i], target)
+		}
+	}
+
+	if !targets[0].isPattern() || targets[1].isPattern() {
+		t.Error("only the first target should be a pattern")
+	}
+}
+
+func TestParseTargetsInvalid(t *testing.T) {
+
+	for _, targetRepository := range []string{"", "no-owner", "owner/repo/extra", "owner/[", "/repo"} {
+		if _, err := parseTargets(targetRepository); err == nil {
+			t.Errorf("expected an error parsing '%v'", targetRepository)
+		}
+	}
+}
+
+func TestInputsForTargetCopiesWorkflowInputs(t *testing.T) {
+
+	fanOutInputs := inputs{
+		checkName:      "deploy",
+		targets:        []target{{owner: "my-org", repository: "service-*"}},
+		workflowInputs: map[string]interface{}{"environment": "production"},
+	}
+
+	scoped := fanOutInputs.forTarget(target{owner: "my-org", repository: "service-a"})
+	scoped.workflowInputs["check_id"] = "1"
+
+	if _, ok := fanOutInputs.workflowInputs["check_id"]; ok {
+		t.Error("workflow inputs were shared with the scoped copy")
+	}
+	if scoped.checkName != "deploy (my-org/service-a)" {
+		t.Errorf("unexpected check name %v", scoped.checkName)
+	}
+	if scoped.isFanOut() {
+		t.Error("scoped inputs should target a single repository")
+	}
+}
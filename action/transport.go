@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/v37/github"
+)
+
+// apiEndpoints are the urls of the GitHub api, which differ from those of
+// github.com on GitHub Enterprise Server
+type apiEndpoints struct {
+	apiUrl    string
+	uploadUrl string
+}
+
+// newApiEndpoints returns the endpoints for the given api url. The upload
+// url is derived from the api url unless one is given
+func newApiEndpoints(apiUrl, uploadUrl string) apiEndpoints {
+	if uploadUrl == "" {
+		uploadUrl = defaultUploadUrl(apiUrl)
+	}
+	return apiEndpoints{apiUrl: strings.TrimSuffix(apiUrl, "/"), uploadUrl: uploadUrl}
+}
+
+// defaultUploadUrl derives the upload url from the api url, which is
+// https://uploads.github.com for github.com and <host>/api/uploads for
+// GitHub Enterprise Server
+func defaultUploadUrl(apiUrl string) string {
+	u, err := url.Parse(apiUrl)
+	if err != nil {
+		return apiUrl
+	}
+
+	if u.Host == "api.github.com" {
+		u.Host = "uploads.github.com"
+		u.Path = "/"
+		return u.String()
+	}
+
+	u.Path = strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), "/api/v3") + "/api/uploads/"
+	return u.String()
+}
+
+// newClient creates a go-github client for the endpoints using the given
+// transport
+func (endpoints apiEndpoints) newClient(transport http.RoundTripper) (*github.Client, error) {
+	client, err := github.NewEnterpriseClient(endpoints.apiUrl, endpoints.uploadUrl, &http.Client{Transport: transport})
+	if err != nil {
+		return nil, newError(errorClassValidation, "Invalid GitHub api url %v or upload url %v: %v", endpoints.apiUrl, endpoints.uploadUrl, err.Error())
+	}
+	return client, nil
+}
+
+// parseTransport builds the base transport used for every request to the
+// GitHub api from the ca_bundle, client_certificate, client_key and proxy
+// inputs. Without them, the default transport is used, which trusts the
+// system certificates and honors the HTTP(S)_PROXY environment variables
+func parseTransport() (http.RoundTripper, error) {
+	caBundle, err := readPEMInput("ca_bundle")
+	if err != nil {
+		return nil, err
+	}
+	clientCertificate, err := readPEMInput("client_certificate")
+	if err != nil {
+		return nil, err
+	}
+	clientKey, err := readPEMInput("client_key")
+	if err != nil {
+		return nil, err
+	}
+	proxy := os.Getenv("INPUT_PROXY")
+
+	if caBundle == nil && clientCertificate == nil && clientKey == nil && proxy == "" {
+		return http.DefaultTransport, nil
+	}
+
+	return newBaseTransport(caBundle, clientCertificate, clientKey, proxy)
+}
+
+// readPEMInput reads an input which may either contain PEM encoded data or
+// be the path to a file containing it
+func readPEMInput(name string) ([]byte, error) {
+	value := strings.TrimSpace(os.Getenv("INPUT_" + strings.ToUpper(name)))
+	if value == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(value, "-----BEGIN") {
+		return []byte(value), nil
+	}
+
+	contents, err := os.ReadFile(value)
+	if err != nil {
+		return nil, fmt.Errorf("input '%v' is neither PEM encoded nor a readable file: %w", name, err)
+	}
+	return contents, nil
+}
+
+// newBaseTransport creates a transport trusting the certificates of the CA
+// bundle (in addition to the system certificates), presenting the client
+// certificate and sending requests through the proxy, where given
+func newBaseTransport(caBundle, clientCertificate, clientKey []byte, proxy string) (http.RoundTripper, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caBundle != nil {
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil || rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if !rootCAs.AppendCertsFromPEM(caBundle) {
+			return nil, errors.New("input 'ca_bundle' contains no PEM encoded certificates")
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if clientCertificate != nil || clientKey != nil {
+		if clientCertificate == nil || clientKey == nil {
+			return nil, errors.New("inputs 'client_certificate' and 'client_key' must be given together")
+		}
+		certificate, err := tls.X509KeyPair(clientCertificate, clientKey)
+		if err != nil {
+			return nil, fmt.Errorf("inputs 'client_certificate' and 'client_key' are not a valid key pair: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	if proxy != "" {
+		proxyUrl, err := url.Parse(proxy)
+		if err != nil || proxyUrl.Scheme == "" || proxyUrl.Host == "" {
+			return nil, fmt.Errorf("input 'proxy' is not a valid url: %v", proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// maxCachedResponses bounds the number of responses kept by an etagTransport
+const maxCachedResponses = 256
+
+// etagTransport makes GET requests conditional on the ETag of the last
+// response to the same url. The GitHub api answers with 304 Not Modified,
+// which does not count against the rate limit, when nothing has changed, in
+// which case the cached response is returned in its place
+type etagTransport struct {
+	base http.RoundTripper
+
+	mu        sync.Mutex
+	responses map[string]cachedResponse
+}
+
+// cachedResponse is a response with an ETag, kept to answer later requests
+// for the same url
+type cachedResponse struct {
+	etag       string
+	statusCode int
+	header     http.Header
+	body       []byte
+}
+
+func newEtagTransport(base http.RoundTripper) *etagTransport {
+	return &etagTransport{base: base, responses: map[string]cachedResponse{}}
+}
+
+func (t *etagTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || req.Header.Get("If-None-Match") != "" {
+		return t.base.RoundTrip(req)
+	}
+
+	key := req.URL.String()
+	t.mu.Lock()
+	cached, ok := t.responses[key]
+	t.mu.Unlock()
+
+	if ok {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		header := cached.header.Clone()
+		// The rate limit headers of the 304 are current
+		for name, values := range resp.Header {
+			if strings.HasPrefix(name, "X-Ratelimit-") {
+				header[name] = values
+			}
+		}
+		return &http.Response{
+			Status:        http.StatusText(cached.statusCode),
+			StatusCode:    cached.statusCode,
+			Proto:         resp.Proto,
+			ProtoMajor:    resp.ProtoMajor,
+			ProtoMinor:    resp.ProtoMinor,
+			Header:        header,
+			Body:          io.NopCloser(bytes.NewReader(cached.body)),
+			ContentLength: int64(len(cached.body)),
+			Request:       req,
+		}, nil
+	}
+
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" {
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	t.mu.Lock()
+	if len(t.responses) >= maxCachedResponses {
+		t.responses = map[string]cachedResponse{}
+	}
+	t.responses[key] = cachedResponse{etag: etag, statusCode: resp.StatusCode, header: resp.Header.Clone(), body: body}
+	t.mu.Unlock()
+
+	return resp, nil
+}
+
+// openDownload opens the body of a download from a url GitHub redirected to,
+// such as the log of a job. The url is pre-authorized, so the credentials of
+// the action are not sent with it, though the base transport is used
+func (client *GitHubClient) openDownload(ctx context.Context, downloadUrl string) (io.ReadCloser, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	transport := client.inputs.baseTransport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	response, err := (&http.Client{Transport: transport}).Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		return nil, fmt.Errorf("unexpected status %v", response.Status)
+	}
+	return response.Body, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"testing"
+
+	"github.com/DrizlyInc/workflow-dispatch-action/action/m/v2/internal/fakegithub"
+)
+
+func TestDefaultUploadUrl(t *testing.T) {
+	cases := map[string]string{
+		"https://api.github.com":           "https://uploads.github.com/",
+		"https://ghes.example.com/api/v3":  "https://ghes.example.com/api/uploads/",
+		"https://ghes.example.com/api/v3/": "https://ghes.example.com/api/uploads/",
+		"https://ghes.example.com/prefix/": "https://ghes.example.com/prefix/api/uploads/",
+	}
+
+	for apiUrl, expected := range cases {
+		if uploadUrl := defaultUploadUrl(apiUrl); uploadUrl != expected {
+			t.Errorf("expected upload url %v for %v, got %v", expected, apiUrl, uploadUrl)
+		}
+	}
+}
+
+func TestNewApiClientEnterpriseServer(t *testing.T) {
+	server := fakegithub.NewTLS()
+	defer server.Close()
+	server.AddRepository("some-org", "target", "trunk")
+
+	githubVars := githubVars{apiUrl: server.URL + "/api/v3"}
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+
+	// Without the CA bundle the certificate of the server is not trusted
+	api, err := newApiClient(context.Background(), githubVars, inputs{
+		credentials:   tokenCredentials{token: "some-token"},
+		baseTransport: http.DefaultTransport,
+	}, "some-org", "target")
+	if err != nil {
+		t.Fatalf("unexpected error creating api client: %v", err)
+	}
+	if _, _, err := api.Repositories.Get(context.Background(), "some-org", "target"); err == nil {
+		t.Error("expected the certificate of the server to be untrusted")
+	}
+
+	transport, err := newBaseTransport(caBundle, nil, nil, "")
+	if err != nil {
+		t.Fatalf("unexpected error creating transport: %v", err)
+	}
+	api, err = newApiClient(context.Background(), githubVars, inputs{
+		credentials:   tokenCredentials{token: "some-token"},
+		baseTransport: transport,
+	}, "some-org", "target")
+	if err != nil {
+		t.Fatalf("unexpected error creating api client: %v", err)
+	}
+	repository, _, err := api.Repositories.Get(context.Background(), "some-org", "target")
+	if err != nil {
+		t.Fatalf("unexpected error fetching repository: %v", err)
+	}
+	if repository.GetDefaultBranch() != "trunk" {
+		t.Errorf("expected default branch trunk, got %v", repository.GetDefaultBranch())
+	}
+
+	requests := server.Requests()
+	if requests[len(requests)-1] != "GET /api/v3/repos/some-org/target" {
+		t.Errorf("expected the request to use the enterprise api url, got %v", requests)
+	}
+}
+
+func TestNewBaseTransportErrors(t *testing.T) {
+	if _, err := newBaseTransport([]byte("not a certificate"), nil, nil, ""); err == nil {
+		t.Error("expected an error for a CA bundle without certificates")
+	}
+	if _, err := newBaseTransport(nil, []byte("-----BEGIN CERTIFICATE-----"), nil, ""); err == nil {
+		t.Error("expected an error for a client certificate without a key")
+	}
+	if _, err := newBaseTransport(nil, nil, nil, "not a url"); err == nil {
+		t.Error("expected an error for an invalid proxy url")
+	}
+}
@@ -5,39 +5,138 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/google/go-github/v37/github"
 	"github.com/sethvargo/go-githubactions"
 )
 
 const secondsBetweenChecks = 5
 
+// Waiter waits for a check to complete, returning its conclusion. The
+// dispatched workflow run is given when known, so that a run which
+// completes without completing the check can be detected, along with
+// whether it was verified, see FindDispatchedWorkflowRun. Close releases
+// whatever the waiter holds once all waiting is done
+type Waiter interface {
+	Wait(ctx context.Context, client *GitHubClient, checkId int64, run *github.WorkflowRun, runVerified bool) (string, error)
+	Close() error
+}
+
+// newWaiter creates the waiter for the wait_strategy input. The webhook
+// strategy starts listening for deliveries immediately, so that none are
+// missed while dispatching
+func newWaiter(inputs inputs) (Waiter, error) {
+	if inputs.waitStrategy != waitStrategyWebhook {
+		return pollingWaiter{}, nil
+	}
+
+	receiver, err := startWebhookReceiver(inputs.webhookAddress, inputs.webhookSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	return webhookWaiter{
+		receiver:      receiver,
+		fallbackAfter: time.Second * time.Duration(inputs.webhookFallbackSeconds),
+		fallback:      pollingWaiter{},
+	}, nil
+}
+
+// checkWaiter returns the waiter for the inputs, polling by default
+func (inputs inputs) checkWaiter() Waiter {
+	if inputs.waiter == nil {
+		return pollingWaiter{}
+	}
+	return inputs.waiter
+}
+
+// pollingWaiter waits by polling the GitHub api, see pollForCheckCompletion
+type pollingWaiter struct{}
+
+func (waiter pollingWaiter) Close() error {
+	return nil
+}
+
+func (waiter pollingWaiter) Wait(ctx context.Context, client *GitHubClient, checkId int64, run *github.WorkflowRun, runVerified bool) (string, error) {
+	return pollForCheckCompletion(ctx, client, checkId, run, runVerified)
+}
+
 // pollForCheckCompletion polls the GitHub api until the given check has
 // a status of "completed" or the timeout specified by the user is reached.
-func pollForCheckCompletion(ctx context.Context, client *GitHubClient, checkId int64) (bool, error) {
+// If the dispatched workflow run was verified and completes without ever
+// completing the check, the check is concluded from the run itself. A run
+// which was not verified may belong to another dispatch, so the check is
+// waited for regardless. The progress of the run is logged while polling,
+// if enabled.
+func pollForCheckCompletion(ctx context.Context, client *GitHubClient, checkId int64, run *github.WorkflowRun, runVerified bool) (string, error) {
 	githubactions.Infof("Waiting for check %v to complete (%vs timeout) ...\n", checkId, client.inputs.waitTimeoutSeconds)
 
+	runCompleted := false
+	pollBackoff := newBackoff(client.inputs.pollBackoff)
+	lastStatus := ""
+	var progress *runProgress
+	if client.inputs.streamProgress && run != nil {
+		progress = newRunProgress(fmt.Sprintf("%v/%v", client.inputs.targetOwner, client.inputs.targetRepository))
+	}
+
 	// loop forever (we handle breaking out later)
 	for {
 
 		check, err := client.FetchCheckWithRetries(ctx, checkId)
 		if err != nil {
-			return false, fmt.Errorf("Error fetching check %v: %w", checkId, err)
+			return "", fmt.Errorf("Error fetching check %v: %w", checkId, err)
 		}
 
 		secondsRemainingUntilTimeout := getSecondsRemaining(ctx)
 		githubactions.Infof("    Check status (%.1fs remaining) ... %v\n", secondsRemainingUntilTimeout, *check.Status)
 
 		if *check.Status == "completed" {
-			return *check.Conclusion == "success", nil
+			// Log the jobs which completed since the last poll, in
+			// particular any which failed
+			if progress != nil {
+				progress.follow(ctx, client, run.GetID())
+			}
+			return check.GetConclusion(), nil
+		}
+
+		// Poll more frequently again whenever the check progresses
+		if *check.Status != lastStatus {
+			lastStatus = *check.Status
+			pollBackoff.reset()
+		}
+
+		// The run finished on a previous poll and the check still has not
+		// been completed, so the target workflow is not updating it
+		if runCompleted {
+			githubactions.Warningf("Workflow run %v completed without completing check %v, using the conclusion of the run", run.GetHTMLURL(), checkId)
+			err := client.CompleteCheckFromRun(ctx, check, run)
+			if err != nil {
+				githubactions.Warningf("%v", err.Error())
+			}
+			return checkConclusionFromRun(run), nil
 		}
 
-		// check if context has been closed(either by timeout or another error in err group).
-		// If so, exit with error.
-		// If not, sleep for a bit and loop again
-		select {
-		case <-ctx.Done():
-			return false, fmt.Errorf("Abandoning check waiting: %w", ctx.Err())
-		default:
-			time.Sleep(time.Second * time.Duration(secondsBetweenChecks))
+		if run != nil {
+			latestRun, err := client.FetchWorkflowRun(ctx, run.GetID())
+			if err != nil {
+				githubactions.Warningf("Error fetching workflow run %v: %v", run.GetID(), err.Error())
+			} else {
+				if latestRun.GetStatus() != run.GetStatus() {
+					pollBackoff.reset()
+				}
+				run = latestRun
+				githubactions.Infof("    Run status ... %v\n", run.GetStatus())
+				runCompleted = runVerified && run.GetStatus() == "completed"
+			}
+			if progress != nil {
+				progress.follow(ctx, client, run.GetID())
+			}
+		}
+
+		// sleep until the next poll, exiting with an error if the context
+		// is closed (either by timeout or another error) in the meantime
+		err = sleepContext(ctx, pollBackoff.next())
+		if err != nil {
+			return "", fmt.Errorf("Abandoning check waiting: %w", err)
 		}
 	}
 }
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v37/github"
+	"github.com/sethvargo/go-githubactions"
+)
+
+const (
+	waitStrategyPoll    = "poll"
+	waitStrategyWebhook = "webhook"
+
+	defaultWebhookAddress         = ":8080"
+	defaultWebhookFallbackSeconds = 120
+
+	// maxWebhookPayloadBytes bounds the size of a webhook delivery, well
+	// above the size of any check_run payload
+	maxWebhookPayloadBytes = 1 << 22
+)
+
+// webhookReceiver accepts check_run webhook deliveries, either directly from
+// GitHub or forwarded by a relay, and passes the check runs of verified
+// deliveries on to the waiters subscribed to them. A single receiver is
+// shared by every dispatch performed by the action
+type webhookReceiver struct {
+	secret []byte
+	server *http.Server
+
+	mu          sync.Mutex
+	subscribers map[int64]chan *github.CheckRun
+}
+
+// checkRunEvent is the payload of a check_run webhook delivery
+type checkRunEvent struct {
+	Action   string           `json:"action"`
+	CheckRun *github.CheckRun `json:"check_run"`
+}
+
+// newWebhookReceiver creates a receiver verifying deliveries with the
+// given secret. It does not listen for deliveries, see startWebhookReceiver
+func newWebhookReceiver(secret string) *webhookReceiver {
+	return &webhookReceiver{
+		secret:      []byte(secret),
+		subscribers: map[int64]chan *github.CheckRun{},
+	}
+}
+
+// startWebhookReceiver creates a receiver listening for deliveries on the
+// given address
+func startWebhookReceiver(address, secret string) (*webhookReceiver, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("Error listening for webhook deliveries on %v: %w", address, err)
+	}
+
+	receiver := newWebhookReceiver(secret)
+	receiver.server = &http.Server{Handler: receiver, ReadHeaderTimeout: time.Second * 10}
+	go func() {
+		err := receiver.server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			githubactions.Warningf("Webhook receiver stopped: %v", err.Error())
+		}
+	}()
+
+	githubactions.Infof("Listening for check_run webhook deliveries on %v\n", listener.Addr())
+	return receiver, nil
+}
+
+// Close stops listening for deliveries
+func (receiver *webhookReceiver) Close() error {
+	if receiver.server == nil {
+		return nil
+	}
+	return receiver.server.Close()
+}
+
+// subscribe returns a channel receiving the check run of each verified
+// delivery for the given check, and a function to unsubscribe
+func (receiver *webhookReceiver) subscribe(checkId int64) (<-chan *github.CheckRun, func()) {
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+
+	events := make(chan *github.CheckRun, 16)
+	receiver.subscribers[checkId] = events
+	return events, func() {
+		receiver.mu.Lock()
+		defer receiver.mu.Unlock()
+		delete(receiver.subscribers, checkId)
+	}
+}
+
+// ServeHTTP handles a webhook delivery, rejecting it unless its
+// X-Hub-Signature-256 header is a valid HMAC of the payload
+func (receiver *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	payload, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookPayloadBytes))
+	if err != nil {
+		http.Error(w, "unable to read payload", http.StatusBadRequest)
+		return
+	}
+
+	if !receiver.validSignature(payload, r.Header.Get("X-Hub-Signature-256")) {
+		githubactions.Warningf("Rejected webhook delivery %v with an invalid signature", r.Header.Get("X-GitHub-Delivery"))
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Header.Get("X-GitHub-Event") != "check_run" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	event := checkRunEvent{}
+	if err := json.Unmarshal(payload, &event); err != nil || event.CheckRun == nil {
+		http.Error(w, "invalid check_run payload", http.StatusBadRequest)
+		return
+	}
+
+	receiver.mu.Lock()
+	events, ok := receiver.subscribers[event.CheckRun.GetID()]
+	if ok {
+		select {
+		case events <- event.CheckRun:
+		default:
+			// The waiter only needs the latest state, which it fetches
+			// when falling back to polling
+		}
+	}
+	receiver.mu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validSignature reports whether the signature header of a delivery is the
+// HMAC-SHA256 of its payload keyed by the secret
+func (receiver *webhookReceiver) validSignature(payload []byte, signatureHeader string) bool {
+	signature, err := hex.DecodeString(strings.TrimPrefix(signatureHeader, "sha256="))
+	if err != nil || !strings.HasPrefix(signatureHeader, "sha256=") {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, receiver.secret)
+	mac.Write(payload)
+	return hmac.Equal(signature, mac.Sum(nil))
+}
+
+// webhookWaiter waits for the check_run deliveries of a check to report
+// it completed, falling back to another waiter (polling) if no deliveries
+// for the check arrive for a while
+type webhookWaiter struct {
+	receiver      *webhookReceiver
+	fallbackAfter time.Duration
+	fallback      Waiter
+}
+
+// Close stops the receiver listening for deliveries, releasing its address
+func (waiter webhookWaiter) Close() error {
+	return waiter.receiver.Close()
+}
+
+func (waiter webhookWaiter) Wait(ctx context.Context, client *GitHubClient, checkId int64, run *github.WorkflowRun, runVerified bool) (string, error) {
+	events, unsubscribe := waiter.receiver.subscribe(checkId)
+	defer unsubscribe()
+
+	// The check may have been completed before subscribing
+	check, err := client.FetchCheckWithRetries(ctx, checkId)
+	if err != nil {
+		return "", fmt.Errorf("Error fetching check %v: %w", checkId, err)
+	}
+	if check.GetStatus() == "completed" {
+		return check.GetConclusion(), nil
+	}
+
+	githubactions.Infof("Waiting for webhook deliveries for check %v (%vs timeout) ...\n", checkId, client.inputs.waitTimeoutSeconds)
+
+	fallbackTimer := time.NewTimer(waiter.fallbackAfter)
+	defer fallbackTimer.Stop()
+
+	for {
+		select {
+		case check := <-events:
+			githubactions.Infof("    Check status (%.1fs remaining) ... %v\n", getSecondsRemaining(ctx), check.GetStatus())
+			if check.GetStatus() == "completed" {
+				return check.GetConclusion(), nil
+			}
+			if !fallbackTimer.Stop() {
+				<-fallbackTimer.C
+			}
+			fallbackTimer.Reset(waiter.fallbackAfter)
+		case <-fallbackTimer.C:
+			githubactions.Warningf("No webhook deliveries received for check %v in %v, falling back to polling", checkId, waiter.fallbackAfter)
+			return waiter.fallback.Wait(ctx, client, checkId, run, runVerified)
+		case <-ctx.Done():
+			return "", fmt.Errorf("Abandoning check waiting: %w", ctx.Err())
+		}
+	}
+}
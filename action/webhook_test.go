@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DrizlyInc/workflow-dispatch-action/action/m/v2/internal/fakegithub"
+)
+
+// deliver sends a check_run webhook delivery for the check to the receiver,
+// signed with the given secret, and returns the status code of the response
+func deliver(receiver *webhookReceiver, secret string, checkId int64, status, conclusion string) int {
+	payload := []byte(fmt.Sprintf(`{"action":"completed","check_run":{"id":%d,"status":%q,"conclusion":%q}}`, checkId, status, conclusion))
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(payload))
+	req.Header.Set("X-GitHub-Event", "check_run")
+	req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	recorder := httptest.NewRecorder()
+	receiver.ServeHTTP(recorder, req)
+	return recorder.Code
+}
+
+func TestWebhookReceiverVerifiesSignature(t *testing.T) {
+	receiver := newWebhookReceiver("secret")
+	events, unsubscribe := receiver.subscribe(1)
+	defer unsubscribe()
+
+	if code := deliver(receiver, "wrong-secret", 1, "completed", "success"); code != http.StatusUnauthorized {
+		t.Errorf("expected a delivery with an invalid signature to be rejected, got %d", code)
+	}
+	if code := deliver(receiver, "secret", 1, "completed", "success"); code != http.StatusNoContent {
+		t.Errorf("expected a delivery with a valid signature to be accepted, got %d", code)
+	}
+
+	select {
+	case check := <-events:
+		if check.GetID() != 1 || check.GetStatus() != "completed" {
+			t.Errorf("unexpected check run delivered: %v", check)
+		}
+	default:
+		t.Error("expected the check run to be delivered")
+	}
+	select {
+	case <-events:
+		t.Error("expected only the verified delivery to be passed on")
+	default:
+	}
+}
+
+func TestWebhookWaiter(t *testing.T) {
+	server, client := newTestClient(t)
+	server.OnDispatch = func(dispatch fakegithub.Dispatch) {
+		server.ScriptCheckRun(dispatchedCheckId(t, dispatch), fakegithub.CheckRunUpdate{Status: "in_progress"})
+	}
+
+	receiver := newWebhookReceiver("secret")
+	client.inputs.waiter = webhookWaiter{
+		receiver:      receiver,
+		fallbackAfter: time.Minute,
+		fallback:      pollingWaiter{},
+	}
+
+	go func() {
+		// Deliver once the waiter has subscribed
+		for deadline := time.Now().Add(time.Second * 5); time.Now().Before(deadline); {
+			for _, dispatch := range server.Dispatches() {
+				checkId := dispatchedCheckId(t, dispatch)
+				receiver.mu.Lock()
+				_, subscribed := receiver.subscribers[checkId]
+				receiver.mu.Unlock()
+				if subscribed {
+					deliver(receiver, "secret", checkId, "completed", "success")
+					return
+				}
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+	}()
+
+	requestsBefore := len(server.Requests())
+	result := dispatchWithClient(context.Background(), client)
+	if !result.Succeeded {
+		t.Fatalf("expected the dispatch to succeed, got error: %v", result.err)
+	}
+
+	for _, request := range server.Requests()[requestsBefore:] {
+		if request == fmt.Sprintf("GET /repos/source-owner/source/check-runs/%d", result.CheckId) {
+			return
+		}
+	}
+	t.Error("expected the check to be fetched before waiting for deliveries")
+}
+
+func TestWebhookWaiterFallsBackToPolling(t *testing.T) {
+	server, client := newTestClient(t)
+	server.OnDispatch = func(dispatch fakegithub.Dispatch) {
+		server.ScriptCheckRun(dispatchedCheckId(t, dispatch),
+			fakegithub.CheckRunUpdate{Status: "in_progress"},
+			fakegithub.CheckRunUpdate{Status: "completed", Conclusion: "success"},
+		)
+	}
+
+	client.inputs.waiter = webhookWaiter{
+		receiver:      newWebhookReceiver("secret"),
+		fallbackAfter: time.Millisecond * 50,
+		fallback:      pollingWaiter{},
+	}
+
+	result := dispatchWithClient(context.Background(), client)
+	if !result.Succeeded {
+		t.Fatalf("expected the dispatch to succeed by polling, got error: %v", result.err)
+	}
+}
+
+func TestPerformDispatchesReleasesWebhookAddress(t *testing.T) {
+	server, client := newTestClient(t)
+	completeOnDispatch(t, server)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	githubVars := client.githubVars
+	githubVars.apiUrl = server.URL
+	inputs := client.inputs
+	inputs.credentials = tokenCredentials{token: "some-token"}
+	inputs.baseTransport = http.DefaultTransport
+	inputs.waitStrategy = waitStrategyWebhook
+	inputs.webhookAddress = address
+	inputs.webhookSecret = "secret"
+	// The check is never delivered, so the waiter falls back to polling
+	inputs.webhookFallbackSeconds = 0
+
+	results, err := performDispatches(context.Background(), githubVars, inputs)
+	if err != nil || len(results) != 1 || !results[0].Succeeded {
+		t.Fatalf("expected the dispatch to succeed, got %v: %v", results, err)
+	}
+
+	listener, err = net.Listen("tcp", address)
+	if err != nil {
+		t.Fatalf("expected the webhook address to be released once waiting ended: %v", err)
+	}
+	listener.Close()
+}
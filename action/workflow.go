@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v37/github"
+	"github.com/sethvargo/go-githubactions"
+	"gopkg.in/yaml.v3"
+)
+
+// maxWorkflowDispatchInputs is the maximum number of inputs GitHub accepts
+// in a single workflow_dispatch event
+const maxWorkflowDispatchInputs = 25
+
+// workflowsDirectory is the directory of a repository containing its workflows
+const workflowsDirectory = ".github/workflows"
+
+// workflowReference identifies the target workflow by its path within the
+// target repository and, when given as input, its numeric ID
+type workflowReference struct {
+	path string
+	id   int64
+}
+
+// filename returns the name of the workflow file, without its directory
+func (workflow workflowReference) filename() string {
+	return path.Base(workflow.path)
+}
+
+// apiIdentifier returns the identifier used for the workflow in GitHub api
+// urls, which accept either the ID or the filename of a workflow
+func (workflow workflowReference) apiIdentifier() string {
+	if workflow.id != 0 {
+		return fmt.Sprint(workflow.id)
+	}
+	return workflow.filename()
+}
+
+// ResolveTargetWorkflow determines the path (and ID, if given) of the
+// workflow to trigger from the workflow_filename input, which may be:
+//   - a numeric workflow ID
+//   - a full path to the workflow file, e.g. .github/workflows/deploy.yaml,
+//     which must be within the workflows directory and have a .yml or
+//     .yaml extension
+//   - a filename with a .yml or .yaml extension, e.g. deploy.yaml
+//   - a basename, in which case the extension is found by probing the
+//     default branch for a .yml and then a .yaml file
+func (client *GitHubClient) ResolveTargetWorkflow(ctx context.Context) error {
+	workflowFilename := client.inputs.workflowFilename
+
+	if id, err := strconv.ParseInt(workflowFilename, 10, 64); err == nil {
+		var workflow *github.Workflow
+		err := client.retry(ctx, fmt.Sprintf("fetching workflow %d", id), retryOptions{}, func(ctx context.Context) error {
+			var err error
+			workflow, _, err = client.api.Actions.GetWorkflowByID(ctx, client.inputs.targetOwner, client.inputs.targetRepository, id)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to fetch workflow with ID %d: %w", id, err)
+		}
+		client.workflow = workflowReference{path: workflow.GetPath(), id: id}
+		return nil
+	}
+
+	workflowFilename = strings.TrimPrefix(workflowFilename, "./")
+	if strings.Contains(workflowFilename, "/") {
+		workflowFilepath := path.Clean(strings.TrimPrefix(workflowFilename, "/"))
+		if path.Dir(workflowFilepath) != workflowsDirectory || !hasWorkflowExtension(workflowFilepath) {
+			return newError(errorClassValidation, "input 'workflow_filename' must be a workflow ID, a filename or a path to a .yml or .yaml file within %v/, got %q", workflowsDirectory, workflowFilename)
+		}
+		client.workflow = workflowReference{path: workflowFilepath}
+		return nil
+	}
+
+	if hasWorkflowExtension(workflowFilename) {
+		client.workflow = workflowReference{path: path.Join(workflowsDirectory, workflowFilename)}
+		return nil
+	}
+
+	defaultBranch, err := client.GetTargetRepositoryDefaultBranch(ctx)
+	if err != nil {
+		return err
+	}
+	for _, extension := range []string{".yml", ".yaml"} {
+		workflowFilepath := path.Join(workflowsDirectory, workflowFilename+extension)
+		exists, err := client.CheckIfFileExistsAtRef(ctx, client.inputs.targetOwner, client.inputs.targetRepository, workflowFilepath, defaultBranch)
+		if err != nil {
+			return err
+		}
+		if exists {
+			client.workflow = workflowReference{path: workflowFilepath}
+			return nil
+		}
+	}
+
+	// Neither exists, fall back to .yml and let validation report it missing
+	client.workflow = workflowReference{path: path.Join(workflowsDirectory, workflowFilename+".yml")}
+	return nil
+}
+
+// hasWorkflowExtension reports whether a filename has one of the
+// extensions GitHub recognizes for workflow files
+func hasWorkflowExtension(filename string) bool {
+	return strings.HasSuffix(filename, ".yml") || strings.HasSuffix(filename, ".yaml")
+}
+
+// workflowDefinition is the subset of a workflow file needed to validate
+// the inputs sent with a workflow_dispatch event. The triggers are kept as
+// a node since "on" may be a string, a list or a map
+type workflowDefinition struct {
+	On      yaml.Node `yaml:"on"`
+	RunName string    `yaml:"run-name"`
+}
+
+// runNameTokenPattern matches a run-name which includes the correlation
+// token of the check_id input, see correlationToken
+var runNameTokenPattern = regexp.MustCompile(`\[\s*\$\{\{\s*(github\.event\.)?inputs\.check_id\s*\}\}\s*\]`)
+
+// runNameCorrelates reports whether the runs of a workflow are named with
+// the correlation token of their check, so they can be identified exactly
+func runNameCorrelates(workflowFile []byte) bool {
+	definition := workflowDefinition{}
+	if err := yaml.Unmarshal(workflowFile, &definition); err != nil {
+		return false
+	}
+	return runNameTokenPattern.MatchString(definition.RunName)
+}
+
+// workflowDispatchTrigger is the configuration of the workflow_dispatch
+// trigger of a workflow
+type workflowDispatchTrigger struct {
+	Inputs map[string]workflowDispatchInput `yaml:"inputs"`
+}
+
+// workflowDispatchInput is a single input declared by the workflow_dispatch
+// trigger of a workflow
+type workflowDispatchInput struct {
+	Description string      `yaml:"description"`
+	Required    bool        `yaml:"required"`
+	Default     interface{} `yaml:"default"`
+	Type        string      `yaml:"type"`
+	Options     []string    `yaml:"options"`
+}
+
+// ValidateTargetWorkflowInputs fetches the workflow file at the target ref
+// and checks that the inputs which will be dispatched match those declared
+// by its workflow_dispatch trigger, returning a validation error describing
+// every mismatch if not
+func (client *GitHubClient) ValidateTargetWorkflowInputs(ctx context.Context) error {
+	workflowFilepath := client.workflow.path
+
+	workflowFile, err := client.FetchFileAtRef(ctx, client.inputs.targetOwner, client.inputs.targetRepository, workflowFilepath, client.inputs.targetRef)
+	if err != nil {
+		return fmt.Errorf("Failed to fetch %v at %v: %w", workflowFilepath, client.inputs.targetRef, err)
+	}
+
+	declaredInputs, err := parseWorkflowDispatchInputs(workflowFile)
+	if err != nil {
+		return newError(errorClassValidation, "Failed to parse %v at %v: %v", workflowFilepath, client.inputs.targetRef, err.Error())
+	}
+
+	// The default inputs are only added when dispatching, so placeholders
+	// stand in for them here
+	providedInputs := map[string]interface{}{}
+	for name, value := range client.inputs.workflowInputs {
+		providedInputs[name] = value
+	}
+	for _, name := range defaultWorkflowInputNames {
+		providedInputs[name] = ""
+	}
+
+	problems := validateWorkflowInputs(declaredInputs, providedInputs)
+	if len(problems) > 0 {
+		return newError(errorClassValidation, "The workflow inputs do not match those declared by %v at %v:\n  - %v", workflowFilepath, client.inputs.targetRef, strings.Join(problems, "\n  - "))
+	}
+
+	client.runNameCorrelates = runNameCorrelates(workflowFile)
+	if !client.runNameCorrelates {
+		githubactions.Warningf("The run-name of %v at %v does not include [${{ inputs.check_id }}], so the run of the dispatch cannot be identified exactly, and a dispatch which fails will not be retried", workflowFilepath, client.inputs.targetRef)
+	}
+
+	return nil
+}
+
+// FetchFileAtRef returns the contents of a file in a repository at a
+// specific ref
+func (client *GitHubClient) FetchFileAtRef(ctx context.Context, owner, repository, filepath, ref string) ([]byte, error) {
+	var fileContent *github.RepositoryContent
+	err := client.retry(ctx, fmt.Sprintf("fetching %v at %v", filepath, ref), retryOptions{}, func(ctx context.Context) error {
+		var err error
+		fileContent, _, _, err = client.api.Repositories.GetContents(ctx, owner, repository, filepath, &github.RepositoryContentGetOptions{
+			Ref: ref,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if fileContent == nil {
+		return nil, fmt.Errorf("%v is a directory", filepath)
+	}
+
+	content, err := fileContent.GetContent()
+	return []byte(content), err
+}
+
+// parseWorkflowDispatchInputs parses a workflow file and returns the
+// inputs declared by its workflow_dispatch trigger
+func parseWorkflowDispatchInputs(workflowFile []byte) (map[string]workflowDispatchInput, error) {
+	definition := workflowDefinition{}
+	err := yaml.Unmarshal(workflowFile, &definition)
+	if err != nil {
+		return nil, err
+	}
+
+	switch definition.On.Kind {
+	case yaml.ScalarNode:
+		if definition.On.Value == "workflow_dispatch" {
+			return map[string]workflowDispatchInput{}, nil
+		}
+	case yaml.SequenceNode:
+		for _, event := range definition.On.Content {
+			if event.Value == "workflow_dispatch" {
+				return map[string]workflowDispatchInput{}, nil
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(definition.On.Content); i += 2 {
+			if definition.On.Content[i].Value != "workflow_dispatch" {
+				continue
+			}
+			trigger := workflowDispatchTrigger{}
+			err := definition.On.Content[i+1].Decode(&trigger)
+			if err != nil {
+				return nil, fmt.Errorf("invalid workflow_dispatch trigger: %w", err)
+			}
+			if trigger.Inputs == nil {
+				trigger.Inputs = map[string]workflowDispatchInput{}
+			}
+			return trigger.Inputs, nil
+		}
+	}
+
+	return nil, errors.New("the workflow is not triggered by workflow_dispatch")
+}
+
+// validateWorkflowInputs compares the inputs which will be dispatched with
+// those declared by the workflow, returning a description of each mismatch
+func validateWorkflowInputs(declared map[string]workflowDispatchInput, provided map[string]interface{}) []string {
+	problems := []string{}
+
+	declaredNames := make([]string, 0, len(declared))
+	for name := range declared {
+		declaredNames = append(declaredNames, name)
+	}
+	sort.Strings(declaredNames)
+
+	providedNames := make([]string, 0, len(provided))
+	for name := range provided {
+		providedNames = append(providedNames, name)
+	}
+	sort.Strings(providedNames)
+
+	if len(provided) > maxWorkflowDispatchInputs {
+		problems = append(problems, fmt.Sprintf("%d inputs would be sent, more than the maximum of %d (including %v)", len(provided), maxWorkflowDispatchInputs, strings.Join(defaultWorkflowInputNames, ", ")))
+	}
+
+	for _, name := range declaredNames {
+		input := declared[name]
+		if _, ok := provided[name]; !ok && input.Required && input.Default == nil {
+			problems = append(problems, fmt.Sprintf("missing required input '%v'", name))
+		}
+	}
+
+	for _, name := range providedNames {
+		input, ok := declared[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("unknown input '%v' (declared inputs: %v)", name, strings.Join(declaredNames, ", ")))
+			continue
+		}
+		if problem := validateWorkflowInputType(name, input, provided[name]); problem != "" {
+			problems = append(problems, problem)
+		}
+	}
+
+	return problems
+}
+
+// validateWorkflowInputType checks that a value is acceptable for the type
+// of a declared input, returning a description of the problem if not
+func validateWorkflowInputType(name string, input workflowDispatchInput, value interface{}) string {
+	stringValue := fmt.Sprint(value)
+
+	switch input.Type {
+	case "boolean":
+		if _, ok := value.(bool); ok {
+			return ""
+		}
+		if stringValue != "true" && stringValue != "false" {
+			return fmt.Sprintf("input '%v' must be a boolean, got '%v'", name, stringValue)
+		}
+	case "number":
+		if _, ok := value.(float64); ok {
+			return ""
+		}
+		if _, err := strconv.ParseFloat(stringValue, 64); err != nil {
+			return fmt.Sprintf("input '%v' must be a number, got '%v'", name, stringValue)
+		}
+	case "choice":
+		for _, option := range input.Options {
+			if option == stringValue {
+				return ""
+			}
+		}
+		return fmt.Sprintf("input '%v' must be one of [%v], got '%v'", name, strings.Join(input.Options, ", "), stringValue)
+	}
+
+	return ""
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+const exampleWorkflow = `
+name: Deploy
+on:
+  push:
+    branches: [main]
+  workflow_dispatch:
+    inputs:
+      check_id:
+        required: true
+      github_repository:
+        required: true
+      github_sha:
+        required: true
+      environment:
+        type: choice
+        required: true
+        options: [staging, production]
+      dry_run:
+        type: boolean
+        default: false
+      replicas:
+        type: number
+`
+
+func TestParseWorkflowDispatchInputs(t *testing.T) {
+
+	declared, err := parseWorkflowDispatchInputs([]byte(exampleWorkflow))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(declared) != 6 {
+		t.Errorf("expected 6 inputs, got %d", len(declared))
+	}
+	if declared["environment"].Type != "choice" || len(declared["environment"].Options) != 2 {
+		t.Errorf("unexpected environment input %v", declared["environment"])
+	}
+}
+
+func TestParseWorkflowDispatchInputsWithoutInputs(t *testing.T) {
+
+	for _, workflow := range []string{"on: workflow_dispatch", "on: [push, workflow_dispatch]", "on:\n  workflow_dispatch:\n"} {
+		declared, err := parseWorkflowDispatchInputs([]byte(workflow))
+		if err != nil {
+			t.Errorf("unexpected error parsing '%v': %v", workflow, err)
+		}
+		if len(declared) != 0 {
+			t.Errorf("expected no inputs parsing '%v'", workflow)
+		}
+	}
+
+	if _, err := parseWorkflowDispatchInputs([]byte("on: push")); err == nil {
+		t.Error("expected an error for a workflow without workflow_dispatch")
+	}
+}
+
+func TestValidateWorkflowInputs(t *testing.T) {
+
+	declared, err := parseWorkflowDispatchInputs([]byte(exampleWorkflow))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	problems := validateWorkflowInputs(declared, map[string]interface{}{
+		"check_id":          "",
+		"github_repository": "",
+		"github_sha":        "",
+		"environment":       "staging",
+		"dry_run":           true,
+		"replicas":          "3",
+	})
+	if len(problems) != 0 {
+		t.Errorf("unexpected problems %v", problems)
+	}
+
+	problems = validateWorkflowInputs(declared, map[string]interface{}{
+		"check_id":    "",
+		"environment": "prod",
+		"dry_run":     "yes",
+		"replicas":    "three",
+		"unexpected":  "value",
+	})
+
+	expected := []string{
+		"missing required input 'github_repository'",
+		"missing required input 'github_sha'",
+		"input 'dry_run' must be a boolean, got 'yes'",
+		"input 'environment' must be one of [staging, production], got 'prod'",
+		"input 'replicas' must be a number, got 'three'",
+		"unknown input 'unexpected'",
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %v", len(expected), problems)
+	}
+	for i, problem := range problems {
+		if !strings.HasPrefix(problem, expected[i]) {
+			t.Errorf("expected '%v', got '%v'", expected[i], problem)
+		}
+	}
+}
+
+func TestRunNameCorrelates(t *testing.T) {
+	cases := map[string]bool{
+		"run-name: Deploy [${{ inputs.check_id }}]":                    true,
+		"run-name: Deploy [${{github.event.inputs.check_id}}] to prod": true,
+		"run-name: Deploy ${{ inputs.check_id }}":                      false,
+		"run-name: Deploy [${{ inputs.environment }}]":                 false,
+		"name: Deploy": false,
+	}
+	for workflow, expected := range cases {
+		if correlates := runNameCorrelates([]byte(workflow + "\non: workflow_dispatch\n")); correlates != expected {
+			t.Errorf("expected %q to correlate=%v", workflow, expected)
+		}
+	}
+}
+
+func TestResolveTargetWorkflow(t *testing.T) {
+	cases := []struct {
+		workflowFilename string
+		path             string
+		id               int64
+		valid            bool
+	}{
+		{"42", ".github/workflows/deploy.yml", 42, true},
+		{".github/workflows/deploy.yml", ".github/workflows/deploy.yml", 0, true},
+		{"./.github/workflows/release.yaml", ".github/workflows/release.yaml", 0, true},
+		{"/.github/workflows/deploy.yml", ".github/workflows/deploy.yml", 0, true},
+		{"deploy.yml", ".github/workflows/deploy.yml", 0, true},
+		{"./release.yaml", ".github/workflows/release.yaml", 0, true},
+		{"deploy", ".github/workflows/deploy.yml", 0, true},
+		{"release", ".github/workflows/release.yaml", 0, true},
+		{"missing", ".github/workflows/missing.yml", 0, true},
+		{"ci/deploy.yml", "", 0, false},
+		{".github/deploy.yml", "", 0, false},
+		{".github/workflows/nested/deploy.yml", "", 0, false},
+		{".github/workflows/../../deploy.yml", "", 0, false},
+		{".github/workflows/deploy.json", "", 0, false},
+		{".github/workflows/deploy", "", 0, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.workflowFilename, func(t *testing.T) {
+			server, client := newTestClient(t)
+			server.AddWorkflow("target-owner", "target", ".github/workflows/release.yaml", testWorkflow, 43)
+			client.inputs.workflowFilename = c.workflowFilename
+
+			err := client.ResolveTargetWorkflow(context.Background())
+			if !c.valid {
+				if errorClassOf(err) != errorClassValidation {
+					t.Errorf("expected a validation error, got %v (resolved %+v)", err, client.workflow)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := (workflowReference{path: c.path, id: c.id}); client.workflow != expected {
+				t.Errorf("expected %+v, got %+v", expected, client.workflow)
+			}
+		})
+	}
+}
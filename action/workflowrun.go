@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v37/github"
+	"github.com/sethvargo/go-githubactions"
+)
+
+const (
+	// defaultRunLookupInterval is the delay between attempts to find the
+	// workflow run created by a dispatch, which takes a few seconds to appear
+	defaultRunLookupInterval = time.Second * 3
+	// defaultRunLookupTimeout bounds how long we search for the dispatched
+	// run
+	defaultRunLookupTimeout = time.Second * 60
+	// runCreationClockSkew allows for differences between the clock of the
+	// runner and the timestamps recorded by GitHub
+	runCreationClockSkew = time.Second * 30
+)
+
+// errWorkflowRunNotFound is the cause of errors returned when no run of the
+// target workflow matched a check within the lookup timeout
+var errWorkflowRunNotFound = errors.New("no matching run was listed within the lookup timeout")
+
+// correlationTokenPattern matches the correlation token of any check, see
+// correlationToken
+var correlationTokenPattern = regexp.MustCompile(`\[\d+\]`)
+
+// workflowRun extends the go-github representation of a workflow run with
+// the display title (set by `run-name` in the target workflow), which the
+// library does not yet expose
+type workflowRun struct {
+	*github.WorkflowRun
+	DisplayTitle *string `json:"display_title,omitempty"`
+}
+
+// FindDispatchedWorkflowRun searches for the workflow run created by the
+// dispatch for the given check, retrying until the run appears or the
+// lookup times out, and reports whether the run was verified. The check ID
+// injected into the workflow inputs serves as the correlation ID: a run
+// whose display title contains it as a token, see correlationToken, is an
+// exact match. When the action performs a single dispatch, a run is
+// otherwise assumed to be the dispatched one if it is the only
+// workflow_dispatch run of the target workflow created since dispatching,
+// and the match is logged as unverified.
+func (client *GitHubClient) FindDispatchedWorkflowRun(ctx context.Context, checkRun *github.CheckRun, dispatchedAt time.Time) (*github.WorkflowRun, bool, error) {
+	allowUnverified := !client.inputs.multipleDispatches
+	run, verified, err := client.lookupWorkflowRun(ctx, checkRun.GetID(), dispatchedAt, allowUnverified)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if !verified {
+		githubactions.Warningf("The title of workflow run %v does not contain %v, so it is only assumed to be the dispatched run as it is the single run created since dispatching. Include the check_id input in the run-name of the target workflow to identify its runs exactly", run.GetID(), correlationToken(checkRun.GetID()))
+	}
+	githubactions.Infof("Dispatched workflow run: %v\n", run.GetHTMLURL())
+	return run, verified, nil
+}
+
+// lookupWorkflowRun polls for the run correlated with the given check until
+// it appears or the lookup times out, see correlateWorkflowRun
+func (client *GitHubClient) lookupWorkflowRun(ctx context.Context, checkId int64, dispatchedAt time.Time, allowUnverified bool) (*github.WorkflowRun, bool, error) {
+	lookupCtx, cancel := context.WithTimeout(ctx, client.inputs.runLookupTimeout)
+	defer cancel()
+
+	// The cause reported if the lookup times out is the error of the last
+	// listing, unless it was only cut short by the timeout
+	var lastErr error
+	for {
+		runs, err := client.listDispatchedWorkflowRuns(lookupCtx, dispatchedAt.Add(-runCreationClockSkew))
+		if err != nil {
+			if lookupCtx.Err() == nil {
+				lastErr = err
+				githubactions.Warningf("Error listing workflow runs: %v", err.Error())
+			}
+		} else if run, _ := correlateWorkflowRun(runs, checkId, false); run != nil {
+			return run, true, nil
+		} else if run, _ := correlateWorkflowRun(createdSince(runs, dispatchedAt.Truncate(time.Second)), checkId, allowUnverified); run != nil {
+			return run, false, nil
+		} else {
+			lastErr = nil
+		}
+
+		select {
+		case <-lookupCtx.Done():
+			cause := lastErr
+			if ctx.Err() != nil {
+				cause = ctx.Err()
+			} else if cause == nil {
+				cause = errWorkflowRunNotFound
+			}
+			return nil, false, fmt.Errorf("Unable to identify the workflow run created for check %v: %w", checkId, cause)
+		case <-time.After(client.inputs.runLookupInterval):
+		}
+	}
+}
+
+// createdSince returns the runs created at or after the given time. Runs
+// are listed from runCreationClockSkew earlier so that an exact match is
+// found regardless of the clock of the runner, but a run is only assumed to
+// be the dispatched one if it was created after dispatching
+func createdSince(runs []*workflowRun, since time.Time) []*workflowRun {
+	created := []*workflowRun{}
+	for _, run := range runs {
+		if !run.GetCreatedAt().Time.Before(since) {
+			created = append(created, run)
+		}
+	}
+	return created
+}
+
+// correlationToken is the token identifying the runs dispatched for a
+// check, which the target workflow includes in its run-name, e.g.
+// `run-name: Deploy [${{ inputs.check_id }}]`
+func correlationToken(checkId int64) string {
+	return fmt.Sprintf("[%d]", checkId)
+}
+
+// correlateWorkflowRun picks the run whose display title contains the
+// correlation token of the check out of the given candidates, and reports
+// whether it matched exactly. If allowed, the only candidate is returned
+// as an unverified match when none matches exactly, unless its title holds
+// the token of another check. Nil is returned if no run can be identified
+func correlateWorkflowRun(runs []*workflowRun, checkId int64, allowUnverified bool) (*github.WorkflowRun, bool) {
+	token := correlationToken(checkId)
+	for _, run := range runs {
+		if strings.Contains(run.GetDisplayTitle(), token) {
+			return run.WorkflowRun, true
+		}
+	}
+
+	if allowUnverified && len(runs) == 1 && !correlationTokenPattern.MatchString(runs[0].GetDisplayTitle()) {
+		return runs[0].WorkflowRun, false
+	}
+
+	return nil, false
+}
+
+// listDispatchedWorkflowRuns lists the workflow_dispatch runs of the target
+// workflow created after the given time. The request is built by hand so
+// the display title of each run is decoded
+func (client *GitHubClient) listDispatchedWorkflowRuns(ctx context.Context, createdAfter time.Time) ([]*workflowRun, error) {
+	query := url.Values{}
+	query.Set("event", "workflow_dispatch")
+	query.Set("created", fmt.Sprintf(">=%s", createdAfter.UTC().Format(time.RFC3339)))
+	query.Set("per_page", "100")
+
+	u := fmt.Sprintf("repos/%v/%v/actions/workflows/%v/runs?%s", client.inputs.targetOwner, client.inputs.targetRepository, url.PathEscape(client.workflow.apiIdentifier()), query.Encode())
+	req, err := client.api.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var runs struct {
+		WorkflowRuns []*workflowRun `json:"workflow_runs"`
+	}
+	err = client.attempt(ctx, func(ctx context.Context) error {
+		_, err := client.api.Do(ctx, req, &runs)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return runs.WorkflowRuns, nil
+}
+
+// FetchWorkflowRun performs a single GetWorkflowRunByID call against the
+// GitHub api to get the current state of a run in the target repository
+func (client *GitHubClient) FetchWorkflowRun(ctx context.Context, runId int64) (*github.WorkflowRun, error) {
+	var run *github.WorkflowRun
+	err := client.attempt(ctx, func(ctx context.Context) error {
+		var err error
+		run, _, err = client.api.Actions.GetWorkflowRunByID(ctx, client.inputs.targetOwner, client.inputs.targetRepository, runId)
+		return err
+	})
+	return run, err
+}
+
+// CancelWorkflowRun requests the cancellation of a run in the target
+// repository. The run is cancelled asynchronously after the request is
+// accepted
+func (client *GitHubClient) CancelWorkflowRun(ctx context.Context, runId int64) error {
+	err := client.retry(ctx, fmt.Sprintf("cancelling workflow run %v", runId), retryOptions{}, func(ctx context.Context) error {
+		_, err := client.api.Actions.CancelWorkflowRunByID(ctx, client.inputs.targetOwner, client.inputs.targetRepository, runId)
+		// The api accepts the cancellation with a 202, which go-github
+		// reports as an error
+		var acceptedErr *github.AcceptedError
+		if errors.As(err, &acceptedErr) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("Error cancelling workflow run %v: %w", runId, err)
+	}
+
+	return nil
+}
+
+// checkConclusionFromRun maps the conclusion of a workflow run to one that
+// is valid for a check run
+func checkConclusionFromRun(run *github.WorkflowRun) string {
+	switch run.GetConclusion() {
+	case "startup_failure", "":
+		return "failure"
+	default:
+		return run.GetConclusion()
+	}
+}
+
+// GetDisplayTitle returns the DisplayTitle field if it's non-nil, zero value otherwise.
+func (run *workflowRun) GetDisplayTitle() string {
+	if run == nil || run.DisplayTitle == nil {
+		return ""
+	}
+	return *run.DisplayTitle
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v37/github"
+)
+
+func TestCorrelateWorkflowRunByDisplayTitle(t *testing.T) {
+
+	runs := []*workflowRun{
+		{WorkflowRun: &github.WorkflowRun{ID: github.Int64(1)}, DisplayTitle: github.String("deploy [1234]")},
+		{WorkflowRun: &github.WorkflowRun{ID: github.Int64(2)}, DisplayTitle: github.String("deploy [5678]")},
+	}
+
+	run, verified := correlateWorkflowRun(runs, 5678, false)
+	if run == nil || run.GetID() != 2 || !verified {
+		t.Errorf("expected run 2 to be verified, got %v (verified=%v)", run, verified)
+	}
+
+	// The check ID must match a whole token, not a prefix of another
+	if run, _ := correlateWorkflowRun(runs, 123, false); run != nil {
+		t.Errorf("expected no run for a check ID prefixing another, got %v", run.GetID())
+	}
+	if run, _ := correlateWorkflowRun(runs[:1], 123, true); run != nil {
+		t.Errorf("expected a run correlated with another check not to be used, got %v", run.GetID())
+	}
+}
+
+func TestCreatedSince(t *testing.T) {
+	dispatchedAt := time.Now().Truncate(time.Second)
+	runs := []*workflowRun{
+		{WorkflowRun: &github.WorkflowRun{ID: github.Int64(1), CreatedAt: &github.Timestamp{Time: dispatchedAt.Add(-time.Second * 10)}}},
+		{WorkflowRun: &github.WorkflowRun{ID: github.Int64(2), CreatedAt: &github.Timestamp{Time: dispatchedAt}}},
+	}
+
+	created := createdSince(runs, dispatchedAt)
+	if len(created) != 1 || created[0].GetID() != 2 {
+		t.Errorf("expected only the run created since dispatching, got %v", created)
+	}
+}
+
+func TestCorrelateWorkflowRunAmbiguous(t *testing.T) {
+
+	runs := []*workflowRun{
+		{WorkflowRun: &github.WorkflowRun{ID: github.Int64(1)}, DisplayTitle: github.String("deploy")},
+		{WorkflowRun: &github.WorkflowRun{ID: github.Int64(2)}, DisplayTitle: github.String("deploy")},
+	}
+
+	if run, _ := correlateWorkflowRun(runs, 5678, true); run != nil {
+		t.Errorf("expected no run, got %v", run.GetID())
+	}
+
+	run, verified := correlateWorkflowRun(runs[:1], 5678, true)
+	if run == nil || run.GetID() != 1 || verified {
+		t.Errorf("expected the single candidate run to be used unverified, got %v (verified=%v)", run, verified)
+	}
+
+	if run, _ := correlateWorkflowRun(runs[:1], 5678, false); run != nil {
+		t.Errorf("expected no run when unverified matches are not allowed, got %v", run.GetID())
+	}
+}